@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	cidr32 "github.com/xenolog/cidr32/v0"
+)
+
+func grepCmd(args []string) int {
+	var opts cidr32.GrepOptions
+	fs := flag.NewFlagSet("grep", flag.ContinueOnError)
+	fs.BoolVar(&opts.Invert, "v", false, "select lines without addresses inside the ranges")
+	fs.BoolVar(&opts.Tag, "t", false, "prefix lines by names of matched ranges")
+	fs.BoolVar(&opts.Count, "c", false, "print amount of matched lines per range instead of lines")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: cidr32 grep [-v] [-t] [-c] [name=]RANGE[,[name=]RANGE...] [FILE...]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exitError
+	}
+	ranges, err := cidr32.ParseNamedRanges(strings.Split(fs.Arg(0), ","))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	total := &cidr32.GrepStats{ByRange: map[string]int{}}
+	grepFile := func(name string, r io.Reader) bool {
+		stats, err := cidr32.GrepStream(r, os.Stdout, ranges, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+			return false
+		}
+		total.Lines = total.Lines + stats.Lines
+		total.Selected = total.Selected + stats.Selected
+		for k, v := range stats.ByRange {
+			total.ByRange[k] = total.ByRange[k] + v
+		}
+		return true
+	}
+
+	failed := false
+	if fs.NArg() == 1 {
+		failed = !grepFile("-", os.Stdin)
+	}
+	for _, name := range fs.Args()[1:] {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+		failed = !grepFile(name, f) || failed
+		f.Close()
+	}
+
+	if opts.Count {
+		for _, e := range ranges.Entries() {
+			name := fmt.Sprint(e.Value)
+			if n, ok := total.ByRange[name]; ok {
+				fmt.Printf("%s\t%d\n", name, n)
+				delete(total.ByRange, name) // ranges with the same name are printed once
+			}
+		}
+		fmt.Printf("total\t%d\n", total.Selected)
+	}
+
+	switch {
+	case failed:
+		return exitError
+	case total.Selected == 0:
+		return exitNoMatched
+	}
+	return exitMatched
+}
@@ -0,0 +1,44 @@
+// Command cidr32 -- command line tools around cidr32 library
+//
+// usage:
+//
+//	cidr32 grep [-v] [-t] [-c] RANGE[,RANGE...] [FILE...]
+//	cidr32 merge [-mem BYTES] [-tmpdir DIR] [FILE...]
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// exit codes like grep(1) has
+const (
+	exitMatched   = 0
+	exitNoMatched = 1
+	exitError     = 2
+)
+
+var commands = map[string]func(args []string) int{
+	"grep":  grepCmd,
+	"merge": mergeCmd,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [options] [args]\n\ncommands:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  grep   print lines with addresses inside (or outside) given ranges\n")
+	fmt.Fprintf(os.Stderr, "  merge  print sorted and glued ranges from huge range files\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitError)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n", os.Args[1])
+		usage()
+		os.Exit(exitError)
+	}
+	os.Exit(cmd(os.Args[2:]))
+}
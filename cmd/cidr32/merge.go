@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	cidr32 "github.com/xenolog/cidr32/v0"
+)
+
+func mergeCmd(args []string) int {
+	opts := &cidr32.MergeOptions{}
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.IntVar(&opts.MemoryLimit, "mem", 64<<20, "memory limit in bytes for in-memory sorting")
+	fs.StringVar(&opts.TempDir, "tmpdir", "", "directory for temporary files")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: cidr32 merge [-mem BYTES] [-tmpdir DIR] [FILE...]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+
+	var err error
+	if fs.NArg() > 0 {
+		err = cidr32.MergeFilesTo(fs.Args(), os.Stdout, opts)
+	} else {
+		err = cidr32.MergeRangesTo(os.Stdin, os.Stdout, opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitMatched
+}
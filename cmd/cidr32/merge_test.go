@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeCmd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cidr32-cmd-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	ioutil.WriteFile(a, []byte("10.0.0.1"), 0644)
+	ioutil.WriteFile(b, []byte("10.0.0.2\n10.0.0.0\n"), 0644)
+
+	out, err := os.Create(filepath.Join(dir, "out.txt"))
+	assert.Nil(t, err)
+	stdout := os.Stdout
+	os.Stdout = out
+	code := mergeCmd([]string{a, b})
+	os.Stdout = stdout
+	out.Close()
+
+	assert.Equal(t, exitMatched, code)
+	data, _ := ioutil.ReadFile(out.Name())
+	assert.Equal(t, "10.0.0.0-10.0.0.2\n", string(data))
+
+	// wrong line of the second file
+	ioutil.WriteFile(b, []byte("10.0.0.2\nwrong\n"), 0644)
+	stderr := os.Stderr
+	errOut, err := os.Create(filepath.Join(dir, "err.txt"))
+	assert.Nil(t, err)
+	os.Stderr = errOut
+	code = mergeCmd([]string{a, b})
+	os.Stderr = stderr
+	errOut.Close()
+
+	assert.Equal(t, exitError, code)
+	data, _ = ioutil.ReadFile(errOut.Name())
+	assert.Contains(t, string(data), b+": line 2 'wrong'")
+}
@@ -0,0 +1,155 @@
+package cidr32
+
+import (
+	"net"
+)
+
+// ACLRule -- rule of the ACL
+type ACLRule struct {
+	Action Action
+	Range  IPRange
+}
+
+// ACL -- ordered list of rules, first matched rule makes the decision.
+// Default action is applied if no rules are matched
+type ACL struct {
+	Rules   []ACLRule
+	Default Action
+}
+
+// ACLIssueKind -- kind of the problem, found by ACL analysis
+type ACLIssueKind int
+
+const (
+	// ACLShadowed -- rule never fires, because earlier rules with
+	// another action cover it
+	ACLShadowed ACLIssueKind = iota
+	// ACLRedundant -- rule can be removed without changing any decision
+	ACLRedundant
+)
+
+func (k ACLIssueKind) String() string {
+	if k == ACLRedundant {
+		return "redundant"
+	}
+	return "shadowed"
+}
+
+// ACLIssue -- problem of the rule with index `Index`.
+// `By` contains indexes of rules, which cause the problem.
+// Empty `By` for redundant rule means the default action
+type ACLIssue struct {
+	Index int
+	Kind  ACLIssueKind
+	By    []int
+}
+
+// NewACL -- returns ACL without rules
+func NewACL(defaultAction Action) *ACL {
+	return &ACL{
+		Rules:   []ACLRule{},
+		Default: defaultAction,
+	}
+}
+
+// Add -- append rule for the range
+func (a *ACL) Add(action Action, r *IPRange) {
+	a.Rules = append(a.Rules, ACLRule{Action: action, Range: *r})
+}
+
+// AddCidr -- append rule for the whole CIDR
+func (a *ACL) AddCidr(action Action, cidr *net.IPNet) error {
+	rng, err := CidrToRange(cidr, false)
+	if err != nil {
+		return err
+	}
+	a.Add(action, rng)
+	return nil
+}
+
+// Evaluate -- returns the first rule, which matches the address, and its index.
+// returns nil and -1 if no rules are matched, i.e. default action is applied
+func (a *ACL) Evaluate(ip uint32) (*ACLRule, int) {
+	for i := range a.Rules {
+		if a.Rules[i].Range.Contains(ip) {
+			return &a.Rules[i], i
+		}
+	}
+	return nil, -1
+}
+
+// Decide -- returns final decision for the address
+func (a *ACL) Decide(ip uint32) Action {
+	if rule, _ := a.Evaluate(ip); rule != nil {
+		return rule.Action
+	}
+	return a.Default
+}
+
+// Compile -- returns map of final decisions (values are Action),
+// which covers whole address space
+func (a *ACL) Compile() *IPRangeMap {
+	return compileRules(a.Rules, a.Default)
+}
+
+func compileRules(rules []ACLRule, defaultAction Action) *IPRangeMap {
+	rv := NewIPRangeMap()
+	rv.Set(&IPRange{i32: [2]uint32{0, ^uint32(0)}}, defaultAction)
+	for i := len(rules) - 1; i >= 0; i-- {
+		rv.Set(&rules[i].Range, rules[i].Action)
+	}
+	rv.glue()
+	return rv
+}
+
+// Analyze -- returns list of shadowed and redundant rules
+func (a *ACL) Analyze() []ACLIssue {
+	rv := []ACLIssue{}
+	for i := range a.Rules {
+		rule := &a.Rules[i]
+		covered := IPRangeList{}
+		by := []int{}
+		sameAction := true
+		for j := 0; j < i; j++ {
+			if rule.Range.IsIntersect(&a.Rules[j].Range) {
+				covered = append(covered, a.Rules[j].Range)
+				by = append(by, j)
+				sameAction = sameAction && a.Rules[j].Action == rule.Action
+			}
+		}
+		fires := IPRangeList{rule.Range}.Subtract(covered)
+		if len(fires) == 0 {
+			kind := ACLShadowed
+			if sameAction {
+				kind = ACLRedundant
+			}
+			rv = append(rv, ACLIssue{Index: i, Kind: kind, By: by})
+			continue
+		}
+		// the rule fires, check whether the rest of ACL makes the same decisions
+		rest := compileRules(a.Rules[i+1:], a.Default)
+		redundant := true
+		for k := 0; k < len(fires) && redundant; k++ {
+			for _, e := range rest.Overlapping(&fires[k]) {
+				if e.Value.(Action) != rule.Action {
+					redundant = false
+					break
+				}
+			}
+		}
+		if !redundant {
+			continue
+		}
+		by = []int{}
+		for j := i + 1; j < len(a.Rules); j++ {
+			for k := range fires {
+				if fires[k].IsIntersect(&a.Rules[j].Range) {
+					by = append(by, j)
+					break
+				}
+			}
+		}
+		rv = append(rv, ACLIssue{Index: i, Kind: ACLRedundant, By: by})
+	}
+	return rv
+}
@@ -0,0 +1,370 @@
+package cidr32
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Allocation -- block of addresses, allocated to the owner
+type Allocation struct {
+	Range    IPRange           `json:"range"`
+	Owner    string            `json:"owner"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// clone -- returns copy of the allocation with own copy of metadata
+func (a *Allocation) clone() Allocation {
+	rv := *a
+	rv.Metadata = copyMetadata(a.Metadata)
+	return rv
+}
+
+func copyMetadata(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	rv := make(map[string]string, len(m))
+	for k, v := range m {
+		rv[k] = v
+	}
+	return rv
+}
+
+// Allocator -- allocator of addresses and blocks from the pool,
+// which uses pluggable Strategy. Safe for concurrent use
+type Allocator struct {
+	mu   sync.Mutex
+	pool IPRangeList
+	// index -- positional index of the pool for strategies, which use it
+	index    *IndexedRangeList
+	reserved IPRangeList
+	strategy Strategy
+	// allocs -- map of allocated ranges to *Allocation
+	allocs *IPRangeMap
+	// quarantine -- optional quarantine of released addresses
+	quarantine *Quarantine
+	// managed -- owner prefix of allocations, which can be made and released
+	// only by the pool tree, see Pool
+	managed string
+}
+
+// NewAllocator -- returns allocator of the pool.
+// FirstFit strategy is used if strategy is nil
+func NewAllocator(pool IPRangeList, strategy Strategy) *Allocator {
+	if strategy == nil {
+		strategy = FirstFit{}
+	}
+	a := &Allocator{
+		reserved: IPRangeList{},
+		strategy: strategy,
+		allocs:   NewIPRangeMap(),
+	}
+	a.setPool(pool)
+	return a
+}
+
+// NewAllocatorFromState -- returns allocator with the saved state.
+// Returns error if reserved or allocated ranges are outside the pool
+// or allocations intersect each other or reserved ranges.
+// FirstFit strategy is used if strategy is nil
+func NewAllocatorFromState(state *AllocatorState, strategy Strategy) (*Allocator, error) {
+	a := NewAllocator(state.Pool, strategy)
+	for i := range state.Reserved {
+		if err := a.Reserve(&state.Reserved[i]); err != nil {
+			return nil, err
+		}
+	}
+	for _, alloc := range state.Allocations {
+		r := alloc.Range
+		if len(IPRangeList{r}.Subtract(a.pool)) > 0 {
+			return nil, fmt.Errorf("Can't restore allocation %s of '%s': outside the pool", &r, alloc.Owner)
+		}
+		for _, e := range a.allocs.Overlapping(&r) {
+			if e.Range.IsIntersect(&r) {
+				return nil, fmt.Errorf("Can't restore allocation %s of '%s': intersects allocation of '%s'", &r, alloc.Owner, e.Value.(*Allocation).Owner)
+			}
+		}
+		for _, res := range a.reserved {
+			if res.IsIntersect(&r) {
+				return nil, fmt.Errorf("Can't restore allocation %s of '%s': intersects reserved %s", &r, alloc.Owner, &res)
+			}
+		}
+		a.allocs.Set(&r, &Allocation{Range: r, Owner: alloc.Owner, Metadata: copyMetadata(alloc.Metadata)})
+	}
+	return a, nil
+}
+
+// SetQuarantine -- addresses, released after this call, will be held
+// in the quarantine and excluded from Free() until they age out
+func (a *Allocator) SetQuarantine(q *Quarantine) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.quarantine = q
+}
+
+// Allocate -- allocate one address to the owner
+func (a *Allocator) Allocate(owner string) (Allocation, error) {
+	return a.AllocateBlock(owner, 1)
+}
+
+// AllocateBlock -- allocate block of `size` contiguous addresses to the owner
+func (a *Allocator) AllocateBlock(owner string, size int) (Allocation, error) {
+	if err := a.checkOwner(owner); err != nil {
+		return Allocation{}, err
+	}
+	return a.allocateBlock(owner, size)
+}
+
+func (a *Allocator) allocateBlock(owner string, size int) (Allocation, error) {
+	if err := checkBlockSize(size); err != nil {
+		return Allocation{}, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var (
+		rng *IPRange
+		err error
+	)
+	if s, ok := a.strategy.(indexedStrategy); ok {
+		rng, err = s.pickIndexed(a.index, a.free(), owner, size)
+	} else {
+		rng, err = a.strategy.Pick(a.pool, a.free(), owner, size)
+	}
+	if err != nil {
+		return Allocation{}, err
+	}
+	return a.add(owner, rng), nil
+}
+
+// AllocateSpecific -- allocate given range to the owner.
+// The range should be inside the pool and free
+func (a *Allocator) AllocateSpecific(owner string, r *IPRange) (Allocation, error) {
+	if err := a.checkOwner(owner); err != nil {
+		return Allocation{}, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(IPRangeList{*r}.Subtract(a.free())) > 0 {
+		return Allocation{}, fmt.Errorf("Can't allocate %s: not free or outside the pool", r)
+	}
+	return a.add(owner, r), nil
+}
+
+// Release -- release the allocation, which contains the address
+func (a *Allocator) Release(ip uint32) error {
+	return a.release(ip, true)
+}
+
+func (a *Allocator) release(ip uint32, checkOwner bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.allocs.Lookup(ip)
+	if !ok {
+		return fmt.Errorf("Allocation of %s not found", Uint32toIP(ip))
+	}
+	alloc := e.Value.(*Allocation)
+	if checkOwner {
+		if err := a.checkOwner(alloc.Owner); err != nil {
+			return err
+		}
+	}
+	a.remove(alloc)
+	return nil
+}
+
+// ReleaseOwner -- release all allocations of the owner.
+// returns amount of released allocations
+func (a *Allocator) ReleaseOwner(owner string) (n int) {
+	if a.checkOwner(owner) != nil {
+		return 0
+	}
+	return a.releaseOwner(owner)
+}
+
+func (a *Allocator) releaseOwner(owner string) (n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, e := range a.allocs.Entries() {
+		if alloc := e.Value.(*Allocation); alloc.Owner == owner {
+			a.remove(alloc)
+			n = n + 1
+		}
+	}
+	return n
+}
+
+// AddPool -- extend the pool by the range
+func (a *Allocator) AddPool(r *IPRange) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.setPool(append(a.pool, *r))
+}
+
+// RemovePool -- exclude the range from the pool.
+// Returns error if any address of the range is allocated, reserved
+// or in quarantine
+func (a *Allocator) RemovePool(r *IPRange) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.allocs.Overlapping(r)) > 0 {
+		return fmt.Errorf("Can't remove %s from the pool: allocated", r)
+	}
+	busy := a.reserved
+	if a.quarantine != nil {
+		busy = append(append(IPRangeList{}, busy...), a.quarantine.Ranges()...)
+	}
+	for i := range busy {
+		if busy[i].IsIntersect(r) {
+			return fmt.Errorf("Can't remove %s from the pool: reserved or in quarantine", r)
+		}
+	}
+	a.setPool(a.pool.Subtract(IPRangeList{*r}))
+	return nil
+}
+
+// Annotate -- set metadata of the allocation, which contains the address
+func (a *Allocator) Annotate(ip uint32, metadata map[string]string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.allocs.Lookup(ip)
+	if !ok {
+		return fmt.Errorf("Allocation of %s not found", Uint32toIP(ip))
+	}
+	e.Value.(*Allocation).Metadata = copyMetadata(metadata)
+	return nil
+}
+
+// Reserve -- exclude the range from allocation.
+// The range should be inside the pool and should not be allocated
+func (a *Allocator) Reserve(r *IPRange) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(IPRangeList{*r}.Subtract(a.pool)) > 0 {
+		return fmt.Errorf("Can't reserve %s: outside the pool", r)
+	}
+	if len(a.allocs.Overlapping(r)) > 0 {
+		return fmt.Errorf("Can't reserve %s: allocated", r)
+	}
+	a.reserved = append(a.reserved, *r).Arranged()
+	return nil
+}
+
+// Unreserve -- return the range to allocation
+func (a *Allocator) Unreserve(r *IPRange) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reserved = a.reserved.Subtract(IPRangeList{*r})
+}
+
+// Reserved -- returns arranged list of reserved addresses
+func (a *Allocator) Reserved() IPRangeList {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append(IPRangeList{}, a.reserved...)
+}
+
+// Lookup -- returns allocation, which contains the address
+func (a *Allocator) Lookup(ip uint32) (Allocation, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if e, ok := a.allocs.Lookup(ip); ok {
+		return e.Value.(*Allocation).clone(), true
+	}
+	return Allocation{}, false
+}
+
+// Allocations -- returns allocations sorted by address
+func (a *Allocator) Allocations() []Allocation {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rv := make([]Allocation, a.allocs.Len())
+	for i, e := range a.allocs.Entries() {
+		rv[i] = e.Value.(*Allocation).clone()
+	}
+	return rv
+}
+
+// Pool -- returns arranged pool
+func (a *Allocator) Pool() IPRangeList {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append(IPRangeList{}, a.pool...)
+}
+
+// Used -- returns arranged list of allocated addresses
+func (a *Allocator) Used() IPRangeList {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.allocs.Ranges().Arranged()
+}
+
+// Free -- returns arranged list of addresses, which can be allocated,
+// i.e. not allocated, not reserved and not in quarantine
+func (a *Allocator) Free() IPRangeList {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.free()
+}
+
+// State -- returns snapshot of pool, reservations and allocations,
+// which can be saved to the Store. Quarantine is not a part of the state
+func (a *Allocator) State() *AllocatorState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rv := &AllocatorState{
+		Pool:        append(IPRangeList{}, a.pool...),
+		Reserved:    append(IPRangeList{}, a.reserved...),
+		Allocations: make([]Allocation, a.allocs.Len()),
+	}
+	for i, e := range a.allocs.Entries() {
+		rv.Allocations[i] = e.Value.(*Allocation).clone()
+	}
+	return rv
+}
+
+// Stats -- returns utilization and fragmentation statistics of the pool
+func (a *Allocator) Stats() *PoolStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return NewPoolStats(a.pool, a.allocs.Ranges())
+}
+
+// setPool -- set the pool and rebuild its index
+func (a *Allocator) setPool(pool IPRangeList) {
+	a.index = pool.Indexed()
+	a.pool = a.index.ranges
+}
+
+// checkOwner -- returns error for owners, managed by the pool tree
+func (a *Allocator) checkOwner(owner string) error {
+	if a.managed != "" && strings.HasPrefix(owner, a.managed) {
+		return fmt.Errorf("Allocations of '%s' are managed by the pool tree", owner)
+	}
+	return nil
+}
+
+func (a *Allocator) free() IPRangeList {
+	rv := a.pool.Subtract(append(a.allocs.Ranges(), a.reserved...))
+	if a.quarantine != nil {
+		rv = rv.Subtract(a.quarantine.Ranges())
+	}
+	return rv
+}
+
+func (a *Allocator) add(owner string, r *IPRange) Allocation {
+	alloc := &Allocation{Range: *r, Owner: owner}
+	a.allocs.Set(r, alloc)
+	if a.quarantine != nil {
+		a.quarantine.Allocated()
+	}
+	return *alloc
+}
+
+func (a *Allocator) remove(alloc *Allocation) {
+	a.allocs.Delete(&alloc.Range)
+	if a.quarantine != nil {
+		tmp, _ := IPRangeList{alloc.Range}.Expand(alloc.Range.Len())
+		a.quarantine.Hold(*tmp...)
+	}
+}
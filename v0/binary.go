@@ -0,0 +1,238 @@
+package cidr32
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Binary format of IPRangeList and IPList:
+//
+//	magic "C32" | version | kind | uvarint count | uvarint deltas... | crc32
+//
+// Deltas are differences between sorted boundaries: first address is stored
+// as is, each next boundary as difference with the previous one.
+// For range lists boundaries are `first, last, first, last...` of arranged list.
+// CRC32 (IEEE) of all previous bytes is stored big endian.
+const (
+	binaryMagic   = "C32"
+	binaryVersion = 1
+	headerLen     = len(binaryMagic) + 2
+	checksumLen   = 4
+)
+
+// kinds of binary encoded data
+const (
+	binaryKindRangeList byte = 1
+	binaryKindIPList    byte = 2
+)
+
+// MarshalBinary -- implements https://godoc.org/encoding#BinaryMarshaler.
+// Range is stored as first and last addresses, big endian
+func (r IPRange) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint32(buf, r.First32())
+	binary.BigEndian.PutUint32(buf[4:], r.Last32())
+	return buf, nil
+}
+
+// UnmarshalBinary -- implements https://godoc.org/encoding#BinaryUnmarshaler
+func (r *IPRange) UnmarshalBinary(data []byte) error {
+	if len(data) != 8 {
+		return fmt.Errorf("Can't decode range: wrong length %d", len(data))
+	}
+	rng, err := New32Range(binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:]))
+	if err != nil {
+		return err
+	}
+	*r = *rng
+	return nil
+}
+
+// MarshalBinary -- implements https://godoc.org/encoding#BinaryMarshaler.
+// List is arranged before encoding
+func (r IPRangeList) MarshalBinary() ([]byte, error) {
+	ranges := r.Arranged()
+	buf := appendBinaryHeader(make([]byte, 0, headerLen+binary.MaxVarintLen64+len(ranges)*4+checksumLen), binaryKindRangeList, len(ranges))
+	prev := uint32(0)
+	for _, rng := range ranges {
+		buf = appendUvarint(buf, uint64(rng.First32()-prev))
+		buf = appendUvarint(buf, uint64(rng.Last32()-rng.First32()))
+		prev = rng.Last32()
+	}
+	return appendChecksum(buf), nil
+}
+
+// UnmarshalBinary -- implements https://godoc.org/encoding#BinaryUnmarshaler
+func (r *IPRangeList) UnmarshalBinary(data []byte) error {
+	enc, err := NewEncodedRangeList(data)
+	if err != nil {
+		return err
+	}
+	rv := make(IPRangeList, 0, enc.Len())
+	enc.Each(func(rng IPRange) bool {
+		rv = append(rv, rng)
+		return true
+	})
+	*r = rv
+	return nil
+}
+
+// MarshalBinary -- implements https://godoc.org/encoding#BinaryMarshaler.
+// List is sorted and deduplicated before encoding
+func (r IPList) MarshalBinary() ([]byte, error) {
+	ips := r.normalized()
+	buf := appendBinaryHeader(make([]byte, 0, headerLen+binary.MaxVarintLen64+len(ips)*2+checksumLen), binaryKindIPList, len(ips))
+	prev := uint32(0)
+	for _, ip := range ips {
+		buf = appendUvarint(buf, uint64(ip-prev))
+		prev = ip
+	}
+	return appendChecksum(buf), nil
+}
+
+// UnmarshalBinary -- implements https://godoc.org/encoding#BinaryUnmarshaler
+func (r *IPList) UnmarshalBinary(data []byte) error {
+	count, body, err := checkBinary(data, binaryKindIPList)
+	if err != nil {
+		return err
+	}
+	rv := make(IPList, 0, count)
+	prev := uint64(0)
+	for i := 0; i < count; i++ {
+		delta, n := binary.Uvarint(body)
+		// delta is checked before the sum, which can wrap around uint64
+		if n <= 0 || delta > uint64(^uint32(0))-prev || (i > 0 && delta == 0) {
+			// addresses of the list are sorted and unique
+			return fmt.Errorf("Can't decode IP list: wrong address #%d", i)
+		}
+		prev = prev + delta
+		rv = append(rv, uint32(prev))
+		body = body[n:]
+	}
+	if len(body) != 0 {
+		return fmt.Errorf("Can't decode IP list: %d unexpected trailing bytes", len(body))
+	}
+	*r = rv
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+// EncodedRangeList -- binary encoded IPRangeList, which is read directly
+// from the underlying bytes without decoding to the IPRangeList
+type EncodedRangeList struct {
+	count int
+	body  []byte
+}
+
+// NewEncodedRangeList -- validate header, checksum and boundaries of the data,
+// which is result of IPRangeList.MarshalBinary. The data is not copied,
+// so it should not be changed while EncodedRangeList is used
+func NewEncodedRangeList(data []byte) (*EncodedRangeList, error) {
+	count, body, err := checkBinary(data, binaryKindRangeList)
+	if err != nil {
+		return nil, err
+	}
+	rv := &EncodedRangeList{count: count, body: body}
+	n := 0
+	rest := rv.walk(func(IPRange) bool {
+		n++
+		return true
+	})
+	if n != count || len(rest) != 0 {
+		return nil, fmt.Errorf("Can't decode range list: wrong boundaries")
+	}
+	return rv, nil
+}
+
+// Len -- returns amount of ranges
+func (e *EncodedRangeList) Len() int {
+	return e.count
+}
+
+// Each -- call `fn` for each range in order until it returns false
+func (e *EncodedRangeList) Each(fn func(IPRange) bool) {
+	e.walk(fn)
+}
+
+// Contains -- returns true if the address is inside one of ranges.
+// Delta encoded ranges are decoded in order up to the address, so it takes O(n).
+// Decode to IPRangeList for repeated lookups with binary search
+func (e *EncodedRangeList) Contains(ip uint32) (rv bool) {
+	e.walk(func(rng IPRange) bool {
+		rv = rng.Contains(ip)
+		return !rv && rng.Last32() < ip
+	})
+	return rv
+}
+
+// walk -- decode ranges until `fn` returns false or wrong data is found.
+// returns rest of undecoded bytes
+func (e *EncodedRangeList) walk(fn func(IPRange) bool) []byte {
+	body := e.body
+	prev := uint64(0)
+	for i := 0; i < e.count; i++ {
+		d1, n1 := binary.Uvarint(body)
+		if n1 <= 0 {
+			return body
+		}
+		d2, n2 := binary.Uvarint(body[n1:])
+		// deltas are checked before sums, which can wrap around uint64
+		if n2 <= 0 || d1 > uint64(^uint32(0))-prev || d2 > uint64(^uint32(0))-(prev+d1) || (i > 0 && d1 < 2) {
+			// ranges of arranged list can't overlap or touch each other
+			return body
+		}
+		first := prev + d1
+		last := first + d2
+		body = body[n1+n2:]
+		prev = last
+		if !fn(IPRange{i32: [2]uint32{uint32(first), uint32(last)}}) {
+			return body
+		}
+	}
+	return body
+}
+
+// ----------------------------------------------------------------------------
+
+func appendBinaryHeader(buf []byte, kind byte, count int) []byte {
+	buf = append(buf, binaryMagic...)
+	buf = append(buf, binaryVersion, kind)
+	return appendUvarint(buf, uint64(count))
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendChecksum(buf []byte) []byte {
+	var tmp [checksumLen]byte
+	binary.BigEndian.PutUint32(tmp[:], crc32.ChecksumIEEE(buf))
+	return append(buf, tmp[:]...)
+}
+
+// checkBinary -- validate header and checksum,
+// returns amount of items and encoded items
+func checkBinary(data []byte, kind byte) (int, []byte, error) {
+	if len(data) < headerLen+1+checksumLen || string(data[:len(binaryMagic)]) != binaryMagic {
+		return 0, nil, fmt.Errorf("Can't decode: wrong header")
+	}
+	if data[len(binaryMagic)] != binaryVersion {
+		return 0, nil, fmt.Errorf("Can't decode: unsupported version %d", data[len(binaryMagic)])
+	}
+	if data[len(binaryMagic)+1] != kind {
+		return 0, nil, fmt.Errorf("Can't decode: unexpected kind %d, should be %d", data[len(binaryMagic)+1], kind)
+	}
+	body, sum := data[:len(data)-checksumLen], data[len(data)-checksumLen:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return 0, nil, fmt.Errorf("Can't decode: checksum mismatch")
+	}
+	count, n := binary.Uvarint(body[headerLen:])
+	if n <= 0 || count > uint64(len(body)) {
+		return 0, nil, fmt.Errorf("Can't decode: wrong amount of items")
+	}
+	return int(count), body[headerLen+n:], nil
+}
@@ -1,8 +1,9 @@
 package cidr32
 
 import (
+	"encoding/binary"
 	"net"
-	"unsafe"
+	"strconv"
 )
 
 // ----------------------------------------------------------------------------
@@ -24,25 +25,41 @@ func Compare32(a, b uint32) int {
 // ----------------------------------------------------------------------------
 
 // IPtoUint32 -- convert net.IP to Uint32
-func IPtoUint32(ip net.IP) (rv uint32) {
-	addr := ip.To4()
-	for i, k := 0, 24; i < 4; i, k = i+1, k-8 {
-		rv = rv + uint32(addr[i])<<k
-	}
-	return rv
+func IPtoUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
 }
 
 // Uint32toIP -- convert Uint32 to net.IP
-func Uint32toIP(ip uint32) (rv net.IP) {
-	tmp := make([]byte, 4)
-	copy(tmp, (*[4]byte)(unsafe.Pointer(&ip))[:])
-	for f, l := 0, len(tmp)-1; f < len(tmp)/2; f, l = f+1, l-1 {
-		tmp[f], tmp[l] = tmp[l], tmp[f]
-	}
-	rv = net.IP(tmp)
+func Uint32toIP(ip uint32) net.IP {
+	rv := make(net.IP, net.IPv4len)
+	PutUint32IP(rv, ip)
 	return rv
 }
 
+// PutUint32IP -- write Uint32 address into the caller-provided buffer,
+// which should be at least 4 bytes long. Does not allocate.
+func PutUint32IP(dst []byte, ip uint32) {
+	binary.BigEndian.PutUint32(dst, ip)
+}
+
+// Uint32to4 -- convert Uint32 to the array of 4 bytes. Does not allocate.
+func Uint32to4(ip uint32) (rv [4]byte) {
+	binary.BigEndian.PutUint32(rv[:], ip)
+	return rv
+}
+
+// AppendUint32IP -- append dotted decimal form of the address to `dst`
+// and return the extended buffer. Does not allocate if `dst` has enough capacity.
+func AppendUint32IP(dst []byte, ip uint32) []byte {
+	for k := 24; k >= 0; k = k - 8 {
+		dst = strconv.AppendUint(dst, uint64(byte(ip>>uint(k))), 10)
+		if k > 0 {
+			dst = append(dst, '.')
+		}
+	}
+	return dst
+}
+
 // NextIP -- returns next IP address
 func NextIP(ip net.IP) net.IP {
 	return Uint32toIP(IPtoUint32(ip) + 1)
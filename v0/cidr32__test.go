@@ -1,9 +1,16 @@
 package cidr32
 
 import (
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -519,3 +526,1686 @@ func TestIPSort(t *testing.T) {
 	)
 
 }
+
+func TestUint32to4(t *testing.T) {
+	assert.Equal(t,
+		[4]byte{127, 248, 192, 129},
+		Uint32to4(uint32(0b01111111111110001100000010000001)),
+	)
+	buf := make([]byte, 4)
+	PutUint32IP(buf, uint32(0b00000001000000100000001100000100))
+	assert.Equal(t,
+		[]byte{1, 2, 3, 4},
+		buf,
+	)
+	assert.Equal(t,
+		"255.0.10.1",
+		string(AppendUint32IP(nil, IPtoUint32(net.ParseIP("255.0.10.1")))),
+	)
+	rng, _ := NewRange("10.0.0.1-10.0.0.254")
+	assert.Equal(t, [4]byte{10, 0, 0, 1}, rng.First4())
+	assert.Equal(t, [4]byte{10, 0, 0, 254}, rng.Last4())
+	assert.Equal(t,
+		"x:10.0.0.1-10.0.0.254",
+		string(rng.AppendTo([]byte("x:"))),
+	)
+}
+
+// sinks of benchmark results, so the compiler can't keep them on the stack
+var (
+	benchString string
+	benchBytes  []byte
+	benchIP     net.IP
+	benchIP4    [4]byte
+)
+
+func BenchmarkRangeString(b *testing.B) {
+	rng, _ := NewRange("255.255.255.255")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchString = rng.String()
+	}
+}
+
+func BenchmarkRangeAppendTo(b *testing.B) {
+	rng, _ := NewRange("172.22.132.10-172.22.132.200")
+	buf := make([]byte, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = rng.AppendTo(buf[:0])
+	}
+	benchBytes = buf
+}
+
+func BenchmarkRangeFirstLast(b *testing.B) {
+	rng, _ := NewRange("172.22.132.10-172.22.132.200")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchIP = rng.First()
+		benchIP = rng.Last()
+	}
+}
+
+func BenchmarkRangeFirstLast4(b *testing.B) {
+	rng, _ := NewRange("172.22.132.10-172.22.132.200")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchIP4 = rng.First4()
+		benchIP4 = rng.Last4()
+	}
+}
+
+func TestIPListUnique(t *testing.T) {
+	allocatedIPs := NewIPList([]string{
+		"192.168.1.40", "192.168.1.38", "192.168.1.40", "wrong", "fe80::1", "192.168.1.38",
+	})
+	assert.Equal(t,
+		"192.168.1.38, 192.168.1.40",
+		allocatedIPs.String(),
+	)
+	ips := IPList{5, 3, 5, 1, 3}
+	ips.Unique()
+	assert.Equal(t, IPList{1, 3, 5}, ips)
+}
+
+func TestNewIPListStrict(t *testing.T) {
+	ips, err := NewIPListStrict([]string{"192.168.1.40", " 192.168.1.38 "})
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.1.38, 192.168.1.40", ips.String())
+
+	ips, err = NewIPListStrict([]string{"192.168.1.40", "192.168.1.300", "fe80::1"})
+	assert.Nil(t, ips)
+	assert.EqualError(t, err, "Can't parse IP list, wrong addresses: '192.168.1.300', 'fe80::1'")
+}
+
+func TestIPListAddRemoveHas(t *testing.T) {
+	ips := NewIPList([]string{"10.0.0.5", "10.0.0.1"})
+	assert.Equal(t, 2, ips.Add(
+		IPtoUint32(net.ParseIP("10.0.0.3")),
+		IPtoUint32(net.ParseIP("10.0.0.5")),
+		IPtoUint32(net.ParseIP("10.0.0.9")),
+	))
+	assert.Equal(t, "10.0.0.1, 10.0.0.3, 10.0.0.5, 10.0.0.9", ips.String())
+	assert.True(t, ips.Has(IPtoUint32(net.ParseIP("10.0.0.3"))))
+	assert.False(t, ips.Has(IPtoUint32(net.ParseIP("10.0.0.4"))))
+
+	assert.Equal(t, 1, ips.Remove(
+		IPtoUint32(net.ParseIP("10.0.0.3")),
+		IPtoUint32(net.ParseIP("10.0.0.4")),
+	))
+	assert.Equal(t, "10.0.0.1, 10.0.0.5, 10.0.0.9", ips.String())
+	assert.False(t, ips.Has(IPtoUint32(net.ParseIP("10.0.0.3"))))
+}
+
+func TestIPListSetOperations(t *testing.T) {
+	a := NewIPList([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.7"})
+	b := NewIPList([]string{"10.0.0.3", "10.0.0.4", "10.0.0.7", "10.0.0.8"})
+	assert.Equal(t,
+		"10.0.0.1, 10.0.0.2, 10.0.0.3, 10.0.0.4, 10.0.0.7, 10.0.0.8",
+		a.Union(*b).String(),
+	)
+	assert.Equal(t,
+		"10.0.0.3, 10.0.0.7",
+		a.Intersect(*b).String(),
+	)
+	assert.Equal(t,
+		"10.0.0.1, 10.0.0.2",
+		a.Difference(*b).String(),
+	)
+	// unsorted operand
+	assert.Equal(t,
+		"10.0.0.1, 10.0.0.2",
+		a.Difference(IPList{(*b)[3], (*b)[0], (*b)[2], (*b)[1]}).String(),
+	)
+}
+
+func TestIPListRanges(t *testing.T) {
+	ips := NewIPList([]string{"10.0.0.1", "10.0.0.5", "10.0.0.10", "10.0.0.21", "10.0.0.30"})
+	r1, _ := NewRange("10.0.0.4-10.0.0.10")
+	r2, _ := NewRange("10.0.0.20-10.0.0.25")
+	rl := IPRangeList{*r2, *r1}
+	assert.Equal(t,
+		"10.0.0.5, 10.0.0.10, 10.0.0.21",
+		ips.IntersectRanges(rl).String(),
+	)
+	assert.Equal(t,
+		"10.0.0.1, 10.0.0.30",
+		ips.DifferenceRanges(rl).String(),
+	)
+	assert.Equal(t,
+		"10.0.0.1-10.0.0.1\n10.0.0.4-10.0.0.10\n10.0.0.20-10.0.0.25\n10.0.0.30-10.0.0.30",
+		ips.UnionRanges(rl).String(),
+	)
+}
+
+func TestIPRangeContains(t *testing.T) {
+	rng, _ := NewRange("10.0.0.4-10.0.0.10")
+	assert.False(t, rng.Contains(IPtoUint32(net.ParseIP("10.0.0.3"))))
+	assert.True(t, rng.Contains(IPtoUint32(net.ParseIP("10.0.0.4"))))
+	assert.True(t, rng.Contains(IPtoUint32(net.ParseIP("10.0.0.10"))))
+	assert.False(t, rng.Contains(IPtoUint32(net.ParseIP("10.0.0.11"))))
+}
+
+func TestArranged(t *testing.T) {
+	r1, _ := NewRange("10.0.0.20-10.0.0.30")
+	r2, _ := NewRange("10.0.0.1-10.0.0.5")
+	r3, _ := NewRange("10.0.0.25-10.0.0.40")
+	r4, _ := NewRange("10.0.0.6-10.0.0.10")
+	r5, _ := NewRange("10.0.0.50-10.0.0.60")
+	r6, _ := NewRange("255.255.255.250-255.255.255.255")
+	r7, _ := NewRange("255.255.255.255")
+	rl := IPRangeList{*r6, *r1, *r2, *r5, *r3, *r7, *r4}
+	assert.Equal(t,
+		"10.0.0.1-10.0.0.5\n10.0.0.6-10.0.0.10\n10.0.0.20-10.0.0.30\n10.0.0.25-10.0.0.40\n10.0.0.50-10.0.0.60\n255.255.255.250-255.255.255.255\n255.255.255.255-255.255.255.255",
+		rl.Sorted().String(),
+	)
+	assert.Equal(t,
+		"10.0.0.1-10.0.0.10\n10.0.0.20-10.0.0.40\n10.0.0.50-10.0.0.60\n255.255.255.250-255.255.255.255",
+		rl.Arranged().String(),
+	)
+	// source list should be untouched
+	assert.Equal(t, *r6, rl[0])
+	// unsorted list glues only neighbours
+	assert.Equal(t,
+		"10.0.0.1-10.0.0.10\n10.0.0.20-10.0.0.30\n10.0.0.1-10.0.0.5",
+		IPRangeList{*r4, *r2, *r1, *r2}.Glued().String(),
+	)
+}
+
+func TestIPListToRangeList(t *testing.T) {
+	ips := NewIPList([]string{
+		"10.0.0.7", "10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.5", "10.0.0.2", "255.255.255.255", "255.255.255.254",
+	})
+	assert.Equal(t,
+		"10.0.0.1-10.0.0.3\n10.0.0.5-10.0.0.5\n10.0.0.7-10.0.0.7\n255.255.255.254-255.255.255.255",
+		ips.ToRangeList().String(),
+	)
+	assert.Equal(t, IPRangeList{}, IPList{}.ToRangeList())
+}
+
+func TestIPRangeListExpand(t *testing.T) {
+	r1, _ := NewRange("10.0.0.5-10.0.0.7")
+	r2, _ := NewRange("10.0.0.1-10.0.0.2")
+	r3, _ := NewRange("10.0.0.6-10.0.0.8")
+	r4, _ := NewRange("255.255.255.255")
+	rl := IPRangeList{*r1, *r2, *r3, *r4}
+	ips, err := rl.Expand(7)
+	assert.Nil(t, err)
+	assert.Equal(t,
+		"10.0.0.1, 10.0.0.2, 10.0.0.5, 10.0.0.6, 10.0.0.7, 10.0.0.8, 255.255.255.255",
+		ips.String(),
+	)
+	assert.Equal(t, rl.Arranged(), ips.ToRangeList())
+
+	ips, err = rl.Expand(6)
+	assert.Error(t, err)
+	assert.Nil(t, ips)
+}
+
+func TestRangeCidrs(t *testing.T) {
+	rng, _ := NewRange("10.0.0.1-10.0.0.18")
+	assert.Equal(t,
+		"[10.0.0.1/32 10.0.0.2/31 10.0.0.4/30 10.0.0.8/29 10.0.0.16/31 10.0.0.18/32]",
+		fmt.Sprint(rng.Cidrs()),
+	)
+	assert.Nil(t, rng.Cidr())
+	assert.Equal(t, "10.0.0.8/29", rng.LargestCidr().String())
+	assert.Equal(t, "10.0.0.4/30", rng.FirstCidr(30).String())
+	assert.Equal(t, "10.0.0.8/29", rng.FirstCidr(29).String())
+	assert.Nil(t, rng.FirstCidr(28))
+
+	rng, _ = NewRange("0.0.0.0-255.255.255.255")
+	assert.Equal(t, "0.0.0.0/0", rng.Cidr().String())
+	assert.Equal(t, "[0.0.0.0/0]", fmt.Sprint(rng.Cidrs()))
+
+	rng, _ = NewRange("192.168.1.0-192.168.1.255")
+	assert.Equal(t, "192.168.1.0/24", rng.Cidr().String())
+	rng, _ = NewRange("192.168.1.7")
+	assert.Equal(t, "192.168.1.7/32", rng.Cidr().String())
+}
+
+func TestSubtract(t *testing.T) {
+	r1, _ := NewRange("10.0.0.0-10.0.0.255")
+	r2, _ := NewRange("10.0.2.0-10.0.2.255")
+	e1, _ := NewRange("10.0.0.10-10.0.0.20")
+	e2, _ := NewRange("10.0.0.15-10.0.0.30")
+	e3, _ := NewRange("10.0.0.200-10.0.2.10")
+	e4, _ := NewRange("10.0.2.255")
+	rl := IPRangeList{*r2, *r1}
+	assert.Equal(t,
+		"10.0.0.0-10.0.0.9\n10.0.0.31-10.0.0.199\n10.0.2.11-10.0.2.254",
+		rl.Subtract(IPRangeList{*e4, *e3, *e2, *e1}).String(),
+	)
+	assert.Equal(t, rl.Arranged(), rl.Subtract(IPRangeList{}))
+	assert.Equal(t, IPRangeList{}, IPRangeList{*e1}.Subtract(rl))
+}
+
+func TestComplementAndGaps(t *testing.T) {
+	universe, _ := NewRange("10.0.0.0-10.0.0.255")
+	u1, _ := NewRange("10.0.0.0-10.0.0.9")
+	u2, _ := NewRange("10.0.0.100-10.0.0.200")
+	u3, _ := NewRange("10.0.0.240-10.0.1.10")
+	used := IPRangeList{*u3, *u1, *u2}
+	assert.Equal(t,
+		"10.0.0.10-10.0.0.99\n10.0.0.201-10.0.0.239",
+		used.Complement(universe).String(),
+	)
+
+	report := used.Gaps(universe)
+	assert.Equal(t, 90+39, report.Free())
+	assert.Len(t, report.Gaps, 2)
+	assert.Equal(t, 90, report.Gaps[0].Size)
+	assert.Equal(t, "10.0.0.32/27", report.Gaps[0].LargestCidr.String())
+	assert.Equal(t, 39, report.Gaps[1].Size)
+	assert.Equal(t, "10.0.0.208/28", report.Gaps[1].LargestCidr.String())
+	// 10.0.0.10/31 10.0.0.12/30 10.0.0.16/28 10.0.0.32/27 10.0.0.64/27 10.0.0.96/30
+	// 10.0.0.201/32 10.0.0.202/31 10.0.0.204/30 10.0.0.208/28 10.0.0.224/28
+	assert.Equal(t,
+		map[int]int{27: 2, 28: 3, 30: 3, 31: 2, 32: 1},
+		report.Histogram,
+	)
+	assert.Equal(t, "10.0.0.32/27", report.Fit(27).String())
+	assert.Equal(t, "10.0.0.16/29", report.Fit(29).String())
+	assert.Nil(t, report.Fit(26))
+}
+
+func TestPoolStats(t *testing.T) {
+	p1, _ := NewRange("10.0.0.0-10.0.0.255")
+	p2, _ := NewRange("10.0.1.0-10.0.1.255")
+	u1, _ := NewRange("10.0.0.0-10.0.0.99")
+	u2, _ := NewRange("10.0.0.50-10.0.0.127") // overlaps u1
+	u3, _ := NewRange("10.0.1.64-10.0.1.64")
+	u4, _ := NewRange("10.0.1.192-10.0.2.10") // partially outside the pool
+	stats := NewPoolStats(IPRangeList{*p2, *p1}, IPRangeList{*u1, *u2, *u3, *u4})
+	assert.Equal(t, 512, stats.Total)
+	assert.Equal(t, 128+1+64, stats.Used)
+	assert.Equal(t, 512-193, stats.Free)
+	assert.InDelta(t, 37.695, stats.Utilization, 0.001)
+	assert.Equal(t, 2, stats.FreeFragments)
+	assert.Equal(t, "10.0.0.128-10.0.1.63", stats.LargestFreeBlock.String())
+	assert.Equal(t, 25, stats.LargestPrefix)
+	assert.True(t, stats.CanAllocate(25))
+	assert.False(t, stats.CanAllocate(24))
+	assert.Equal(t, 1, stats.Allocatable[25])
+	assert.Equal(t, 3+1, stats.Allocatable[26])
+	assert.Equal(t, 319, stats.Allocatable[32])
+	assert.InDelta(t, 1-192.0/319.0, stats.Fragmentation, 0.0001)
+
+	stats = NewPoolStats(IPRangeList{*p1}, IPRangeList{*p1})
+	assert.Equal(t, 0, stats.Free)
+	assert.Equal(t, float64(100), stats.Utilization)
+	assert.Nil(t, stats.LargestFreeBlock)
+	assert.Equal(t, -1, stats.LargestPrefix)
+	assert.Equal(t, float64(0), stats.Fragmentation)
+}
+
+func TestFirewallExport(t *testing.T) {
+	r1, _ := NewRange("10.0.0.5-10.0.0.10")
+	r2, _ := NewRange("10.0.1.0-10.0.1.255")
+	r3, _ := NewRange("10.0.2.1")
+	rl := IPRangeList{*r2, *r3, *r1}
+
+	buf := &strings.Builder{}
+	assert.Nil(t, WriteIPSet(buf, rl, "blocked", IPSetHashNet))
+	assert.Equal(t, `create blocked hash:net family inet -exist
+add blocked 10.0.0.5/32 -exist
+add blocked 10.0.0.6/31 -exist
+add blocked 10.0.0.8/31 -exist
+add blocked 10.0.0.10/32 -exist
+add blocked 10.0.1.0/24 -exist
+add blocked 10.0.2.1/32 -exist
+`, buf.String())
+
+	buf.Reset()
+	assert.Nil(t, WriteIPSet(buf, rl, "blocked", IPSetBitmapIP))
+	assert.Equal(t, `create blocked bitmap:ip range 10.0.0.5-10.0.2.1 -exist
+add blocked 10.0.0.5-10.0.0.10 -exist
+add blocked 10.0.1.0/24 -exist
+add blocked 10.0.2.1 -exist
+`, buf.String())
+	r4, _ := NewRange("10.2.0.0")
+	assert.Error(t, WriteIPSet(buf, append(rl, *r4), "blocked", IPSetBitmapIP))
+	assert.Error(t, WriteIPSet(buf, rl, "blocked", "hash:ip,port"))
+
+	buf.Reset()
+	all, _ := NewRange("0.0.0.0/0")
+	assert.Nil(t, WriteIPSet(buf, IPRangeList{*all}, "all", IPSetHashNet))
+	assert.Equal(t, `create all hash:net family inet -exist
+add all 0.0.0.0/1 -exist
+add all 128.0.0.0/1 -exist
+`, buf.String())
+
+	// more CIDRs than default maxelem of hash:net
+	many := make(IPRangeList, hashNetMaxElem+1)
+	for i := range many {
+		many[i] = IPRange{i32: [2]uint32{uint32(2 * i), uint32(2 * i)}}
+	}
+	buf.Reset()
+	assert.Nil(t, WriteIPSet(buf, many, "many", IPSetHashNet))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Len(t, lines, hashNetMaxElem+2)
+	assert.Equal(t, "create many hash:net family inet hashsize 131072 maxelem 65537 -exist", lines[0])
+	assert.Equal(t, "add many 0.2.0.0/32 -exist", lines[len(lines)-1])
+
+	buf.Reset()
+	assert.Nil(t, WriteNftSet(buf, rl, "inet", "filter", "blocked"))
+	assert.Equal(t, "table inet filter {\n"+
+		"\tset blocked {\n"+
+		"\t\ttype ipv4_addr\n"+
+		"\t\tflags interval\n"+
+		"\t\telements = { 10.0.0.5-10.0.0.10, 10.0.1.0/24, 10.0.2.1 }\n"+
+		"\t}\n"+
+		"}\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(t, WriteIptables(buf, rl, "INPUT", "src", "DROP"))
+	assert.Equal(t, `-A INPUT -m iprange --src-range 10.0.0.5-10.0.0.10 -j DROP
+-A INPUT -s 10.0.1.0/24 -j DROP
+-A INPUT -s 10.0.2.1 -j DROP
+`, buf.String())
+	assert.Error(t, WriteIptables(buf, rl, "INPUT", "any", "DROP"))
+
+	buf.Reset()
+	assert.Nil(t, WritePfTable(buf, rl))
+	assert.Equal(t, `10.0.0.5
+10.0.0.6/31
+10.0.0.8/31
+10.0.0.10
+10.0.1.0/24
+10.0.2.1
+`, buf.String())
+}
+
+func TestPrefixList(t *testing.T) {
+	r1, _ := NewRange("10.0.0.0-10.0.0.127")
+	r2, _ := NewRange("10.0.0.128-10.0.0.255") // will be aggregated with r1
+	_, p1, _ := net.ParseCIDR("192.168.0.0/16")
+	_, p2, _ := net.ParseCIDR("172.16.0.0/12")
+
+	pl := NewPrefixList("PL-TEST")
+	assert.Nil(t, pl.AddPrefixes(Deny, []*net.IPNet{p1}, 0, 0))
+	assert.Nil(t, pl.AddRanges(Permit, IPRangeList{*r2, *r1}, 0, 28))
+	assert.Nil(t, pl.AddPrefixes(Permit, []*net.IPNet{p2}, 16, 24))
+	assert.Error(t, pl.AddPrefixes(Permit, []*net.IPNet{p2}, 12, 24))
+	assert.Error(t, pl.AddPrefixes(Permit, []*net.IPNet{p2}, 20, 16))
+	assert.Len(t, pl.Entries, 3)
+
+	buf := &strings.Builder{}
+	assert.Nil(t, pl.WriteCisco(buf))
+	assert.Equal(t, `ip prefix-list PL-TEST seq 5 deny 192.168.0.0/16
+ip prefix-list PL-TEST seq 10 permit 10.0.0.0/24 le 28
+ip prefix-list PL-TEST seq 15 permit 172.16.0.0/12 ge 16 le 24
+`, buf.String())
+
+	frr := &strings.Builder{}
+	assert.Nil(t, pl.WriteFRR(frr))
+	assert.Equal(t, buf.String(), frr.String())
+
+	buf.Reset()
+	assert.Nil(t, pl.WriteJuniper(buf))
+	assert.Equal(t, `policy-options {
+    policy-statement PL-TEST {
+        term seq-5 {
+            from route-filter 192.168.0.0/16 exact;
+            then reject;
+        }
+        term seq-10 {
+            from route-filter 10.0.0.0/24 upto /28;
+            then accept;
+        }
+        term seq-15 {
+            from route-filter 172.16.0.0/12 prefix-length-range /16-/24;
+            then accept;
+        }
+        term default {
+            then reject;
+        }
+    }
+}
+`, buf.String())
+
+	buf.Reset()
+	assert.Nil(t, pl.WriteBird(buf))
+	assert.Equal(t, "filter PL-TEST {\n"+
+		"\tif net ~ [ 192.168.0.0/16 ] then reject; # seq 5\n"+
+		"\tif net ~ [ 10.0.0.0/24{24,28} ] then accept; # seq 10\n"+
+		"\tif net ~ [ 172.16.0.0/12{16,24} ] then accept; # seq 15\n"+
+		"\treject;\n"+
+		"}\n", buf.String())
+}
+
+func TestIPRangeMap(t *testing.T) {
+	m := NewIPRangeMap()
+	r1, _ := NewRange("10.0.0.0-10.0.0.255")
+	r2, _ := NewRange("10.0.0.100-10.0.0.150")
+	r3, _ := NewRange("10.0.0.140-10.0.1.10")
+	r4, _ := NewRange("10.0.2.0-10.0.2.10")
+	r5, _ := NewRange("10.0.0.10-10.0.0.20")
+	m.Set(r1, "a")
+	m.Set(r2, "b")
+	m.Set(r4, "d")
+	m.Set(r3, "c")
+	m.Set(r5, "e")
+	assert.Equal(t, 6, m.Len())
+	assert.Equal(t,
+		"10.0.0.0-10.0.0.9 a\n10.0.0.10-10.0.0.20 e\n10.0.0.21-10.0.0.99 a\n10.0.0.100-10.0.0.139 b\n10.0.0.140-10.0.1.10 c\n10.0.2.0-10.0.2.10 d",
+		m.String(),
+	)
+
+	e, ok := m.Lookup(IPtoUint32(net.ParseIP("10.0.0.139")))
+	assert.True(t, ok)
+	assert.Equal(t, "b", e.Value)
+	assert.Equal(t, "10.0.0.100-10.0.0.139", e.Range.String())
+	e, ok = m.Lookup(IPtoUint32(net.ParseIP("10.0.1.11")))
+	assert.False(t, ok)
+	assert.Nil(t, e.Value)
+
+	o, _ := NewRange("10.0.0.99-10.0.0.140")
+	assert.Len(t, m.Overlapping(o), 3)
+	assert.Equal(t,
+		"10.0.0.0-10.0.1.10\n10.0.2.0-10.0.2.10",
+		m.Ranges().Arranged().String(),
+	)
+}
+
+func TestACL(t *testing.T) {
+	acl := NewACL(Deny)
+	r0, _ := NewRange("10.0.0.13")
+	r1, _ := NewRange("10.0.0.0-10.0.0.255")
+	r2, _ := NewRange("10.0.0.10-10.0.0.20")   // shadowed by r1
+	r3, _ := NewRange("10.0.0.100-10.0.0.110") // redundant, covered by r1
+	r4, _ := NewRange("10.0.1.0-10.0.1.10")    // redundant, default is deny
+	_, c5, _ := net.ParseCIDR("10.0.2.0/24")
+	acl.Add(Deny, r0)
+	acl.Add(Permit, r1)
+	acl.Add(Deny, r2)
+	acl.Add(Permit, r3)
+	acl.Add(Deny, r4)
+	assert.Nil(t, acl.AddCidr(Permit, c5))
+
+	rule, i := acl.Evaluate(IPtoUint32(net.ParseIP("10.0.0.13")))
+	assert.Equal(t, 0, i)
+	assert.Equal(t, Deny, rule.Action)
+	rule, i = acl.Evaluate(IPtoUint32(net.ParseIP("10.0.0.14")))
+	assert.Equal(t, 1, i)
+	assert.Equal(t, Permit, rule.Action)
+	rule, i = acl.Evaluate(IPtoUint32(net.ParseIP("10.0.3.1")))
+	assert.Equal(t, -1, i)
+	assert.Nil(t, rule)
+	assert.Equal(t, Deny, acl.Decide(IPtoUint32(net.ParseIP("10.0.3.1"))))
+	assert.Equal(t, Permit, acl.Decide(IPtoUint32(net.ParseIP("10.0.2.1"))))
+
+	compiled := acl.Compile()
+	assert.Equal(t,
+		"0.0.0.0-9.255.255.255 deny\n10.0.0.0-10.0.0.12 permit\n10.0.0.13-10.0.0.13 deny\n10.0.0.14-10.0.0.255 permit\n10.0.1.0-10.0.1.255 deny\n10.0.2.0-10.0.2.255 permit\n10.0.3.0-255.255.255.255 deny",
+		compiled.String(),
+	)
+	for _, s := range []string{"10.0.0.1", "10.0.0.13", "10.0.0.15", "10.0.0.105", "10.0.1.5", "10.0.2.5", "10.0.3.5"} {
+		ip := IPtoUint32(net.ParseIP(s))
+		e, ok := compiled.Lookup(ip)
+		assert.True(t, ok)
+		assert.Equal(t, acl.Decide(ip), e.Value, s)
+	}
+
+	assert.Equal(t,
+		[]ACLIssue{
+			{Index: 2, Kind: ACLShadowed, By: []int{0, 1}},
+			{Index: 3, Kind: ACLRedundant, By: []int{1}},
+			{Index: 4, Kind: ACLRedundant, By: []int{}},
+		},
+		acl.Analyze(),
+	)
+}
+
+func TestNewRangeCidr(t *testing.T) {
+	rng, err := NewRange("10.0.1.0/24")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.1.0-10.0.1.255", rng.String())
+	_, err = NewRange("10.0.1.0/33")
+	assert.Error(t, err)
+	_, err = NewRange("fe80::/64")
+	assert.Error(t, err)
+	_, err = NewRange("fe80::1")
+	assert.Error(t, err)
+	_, err = NewRange("10.0.0.1-10.0.0.2-10.0.0.3")
+	assert.Error(t, err)
+
+	for in, out := range map[string]string{
+		"10.0.1.5/24":              "10.0.1.0-10.0.1.255",
+		" 10.0.1.5/32 ":            "10.0.1.5-10.0.1.5",
+		"0.0.0.0/0":                "0.0.0.0-255.255.255.255",
+		" 10.0.0.1 - 10.0.0.9 ":    "10.0.0.1-10.0.0.9",
+		"10.0.0.1":                 "10.0.0.1-10.0.0.1",
+		"::ffff:10.0.0.1-10.0.0.2": "10.0.0.1-10.0.0.2",
+		"::ffff:10.0.0.0/120":      "10.0.0.0-10.0.0.255",
+		"::ffff:0.0.0.0/96":        "0.0.0.0-255.255.255.255",
+	} {
+		rng, err := NewRange(in)
+		assert.Nil(t, err, in)
+		assert.Equal(t, out, rng.String(), in)
+	}
+	for _, in := range []string{"", "10.0.0.1-", "10.0.0.1-fe80::1", "10.0.0.9-10.0.0.1", "10.0.0.0/24-10.0.1.0", "10.0.0.0/x", "::/0", "::ffff:0.0.0.0/95"} {
+		_, err := NewRange(in)
+		assert.Error(t, err, in)
+	}
+	_, cidr, _ := net.ParseCIDR("fe80::/64")
+	_, err = CidrToRange(cidr, false)
+	assert.Error(t, err)
+	_, err = CidrToRange(&net.IPNet{IP: net.IPv4(10, 0, 0, 0), Mask: net.IPMask{255, 0, 255, 0}}, false)
+	assert.Error(t, err)
+}
+
+func TestIPRangeListContains(t *testing.T) {
+	r1, _ := NewRange("10.0.0.0/24")
+	r2, _ := NewRange("10.0.5.1-10.0.5.7")
+	rl := IPRangeList{*r2, *r1}.Arranged()
+	assert.True(t, rl.Contains(IPtoUint32(net.ParseIP("10.0.0.0"))))
+	assert.True(t, rl.Contains(IPtoUint32(net.ParseIP("10.0.5.7"))))
+	assert.False(t, rl.Contains(IPtoUint32(net.ParseIP("10.0.1.0"))))
+	assert.False(t, rl.Contains(IPtoUint32(net.ParseIP("10.0.5.8"))))
+	assert.False(t, IPRangeList{}.Contains(0))
+}
+
+func TestGrepStream(t *testing.T) {
+	ranges, err := ParseNamedRanges([]string{"office=10.0.0.0/24", "dmz=10.0.5.1-10.0.5.7", "10.0.0.200"})
+	assert.Nil(t, err)
+	input := `Jan 1 sshd: accepted from 10.0.0.15 port 22
+Jan 1 sshd: failed from 192.168.1.1.
+Jan 1 proxy: 10.0.5.3 -> 10.0.0.200:443
+Jan 1 kernel: version 1.2.3.4.5 10.0.5.300
+no addresses here`
+
+	out := &strings.Builder{}
+	stats, err := GrepStream(strings.NewReader(input), out, ranges, GrepOptions{Tag: true})
+	assert.Nil(t, err)
+	assert.Equal(t, `office: Jan 1 sshd: accepted from 10.0.0.15 port 22
+dmz,office: Jan 1 proxy: 10.0.5.3 -> 10.0.0.200:443
+`, out.String())
+	assert.Equal(t, 5, stats.Lines)
+	assert.Equal(t, 2, stats.Selected)
+	assert.Equal(t, map[string]int{"office": 2, "dmz": 1}, stats.ByRange)
+
+	out.Reset()
+	stats, err = GrepStream(strings.NewReader(input), out, ranges, GrepOptions{Invert: true})
+	assert.Nil(t, err)
+	assert.Equal(t, `Jan 1 sshd: failed from 192.168.1.1.
+Jan 1 kernel: version 1.2.3.4.5 10.0.5.300
+no addresses here
+`, out.String())
+	assert.Equal(t, 3, stats.Selected)
+
+	out.Reset()
+	stats, err = GrepStream(strings.NewReader(input), out, ranges, GrepOptions{Count: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "", out.String())
+	assert.Equal(t, 2, stats.Selected)
+
+	_, err = ParseNamedRanges([]string{"bad=10.0.0.0/40"})
+	assert.Error(t, err)
+}
+
+func TestReadFeed(t *testing.T) {
+	firehol := `#
+# firehol_level1
+#
+1.2.3.0/24
+5.6.7.8
+bad-line
+10.0.0.0/8
+`
+	rl, err := ReadFeedList(strings.NewReader(firehol), FeedFireHOL)
+	assert.Equal(t, "1.2.3.0-1.2.3.255\n5.6.7.8-5.6.7.8\n10.0.0.0-10.255.255.255", rl.String())
+	assert.EqualError(t, err, "line 6 'bad-line': Can't parse range 'bad-line': addr 'bad' wrong")
+	assert.Equal(t, 6, err.(FeedErrors)[0].Line)
+
+	spamhaus := `; Spamhaus DROP List 2026/10/18 - (c) 2026 The Spamhaus Project
+; Last-Modified: Sat, 18 Oct 2026 00:00:00 GMT
+
+1.10.16.0/20 ; SBL256894
+1.19.0.0/16 ; SBL434604
+`
+	m, err := ReadFeedMap(strings.NewReader(spamhaus), FeedSpamhaus, "drop")
+	assert.Nil(t, err)
+	assert.Equal(t, "1.10.16.0-1.10.31.255 SBL256894\n1.19.0.0-1.19.255.255 SBL434604", m.String())
+
+	p2p := `# PeerGuardian
+Some Org: Inc:1.2.4.0-1.2.4.255
+Other:1.2.8.1-1.2.8.5
+Broken:1.2.8.5-1.2.8.1
+`
+	entries, err := ReadFeed(strings.NewReader(p2p), FeedP2P)
+	assert.Error(t, err)
+	assert.Len(t, err.(FeedErrors), 1)
+	assert.Equal(t, 4, err.(FeedErrors)[0].Line)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "Some Org: Inc", entries[0].Label)
+	assert.Equal(t, "1.2.4.0-1.2.4.255", entries[0].Range.String())
+	assert.Equal(t, "Other", entries[1].Label)
+
+	dshield := "# DShield.org Recommended Block List\n" +
+		"Start\tEnd\tNetblock\tAttacks\tName\tCountry\temail\n" +
+		"45.148.10.0\t45.148.10.255\t24\t8512\tEXAMPLE-NET\tNL\tabuse@example.net\n" +
+		"80.82.77.0\t80.82.77.255\t24\t4005\t\tSC\t\n"
+	m, err = ReadFeedMap(strings.NewReader(dshield), FeedDShield, "dshield")
+	assert.Nil(t, err)
+	assert.Equal(t, "45.148.10.0-45.148.10.255 EXAMPLE-NET\n80.82.77.0-80.82.77.255 dshield", m.String())
+
+	plain := "10.0.0.1 # router\n10.0.0.5-10.0.0.9\n"
+	rl, err = ReadFeedList(strings.NewReader(plain), FeedPlain)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1-10.0.0.1\n10.0.0.5-10.0.0.9", rl.String())
+
+	_, err = ReadFeed(strings.NewReader(plain), FeedFormat(42))
+	assert.Error(t, err)
+}
+
+func TestCloudRanges(t *testing.T) {
+	aws := `{
+  "syncToken": "1700000000",
+  "createDate": "2026-10-18-00-00-00",
+  "prefixes": [
+    {"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON", "network_border_group": "ap-northeast-2"},
+    {"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "S3", "network_border_group": "ap-northeast-2"},
+    {"ip_prefix": "13.34.37.64/27", "region": "ap-southeast-4", "service": "AMAZON", "network_border_group": "ap-southeast-4"},
+    {"ip_prefix": "13.34.37.96/27", "region": "ap-southeast-4", "service": "AMAZON", "network_border_group": "ap-southeast-4"}
+  ],
+  "ipv6_prefixes": [
+    {"ipv6_prefix": "2600:1ff2:4000::/40", "region": "us-west-2", "service": "AMAZON", "network_border_group": "us-west-2"}
+  ]
+}`
+	c, err := ReadAWSRanges(strings.NewReader(aws))
+	assert.Nil(t, err)
+	assert.Len(t, c.IPv4, 4)
+	assert.Len(t, c.IPv6, 1)
+	assert.Equal(t, []string{"AMAZON", "S3"}, c.Services())
+	assert.Equal(t, []string{"ap-northeast-2", "ap-southeast-4", "us-west-2"}, c.Regions())
+	assert.Equal(t, "3.5.140.0-3.5.143.255", c.Filter("s3", "").String())
+	assert.Equal(t, "13.34.37.64-13.34.37.127", c.Filter("AMAZON", "ap-southeast-4").String())
+	assert.Equal(t, 1024+64, c.Filter("", "").Capacity())
+
+	gcp := `{"syncToken": "1", "creationTime": "2026-10-18T00:00:00", "prefixes": [
+		{"ipv4Prefix": "34.1.208.0/20", "service": "Google Cloud", "scope": "africa-south1"},
+		{"ipv6Prefix": "2600:1900:8000::/44", "service": "Google Cloud", "scope": "africa-south1"},
+		{"ipv4Prefix": "34.35.0.0/16", "service": "Google Cloud", "scope": "us-east1"}
+	]}`
+	c, err = ReadGCPRanges(strings.NewReader(gcp))
+	assert.Nil(t, err)
+	assert.Len(t, c.IPv6, 1)
+	assert.Equal(t, "34.1.208.0-34.1.223.255", c.Filter("Google Cloud", "africa-south1").String())
+
+	azure := `{"changeNumber": 1, "cloud": "Public", "values": [
+		{"name": "Storage.EastUS", "id": "Storage.EastUS", "properties": {
+			"region": "eastus", "systemService": "AzureStorage",
+			"addressPrefixes": ["20.38.98.0/24", "2603:1030:20e::/48"]}},
+		{"name": "AzureFrontDoor.Backend", "id": "AzureFrontDoor.Backend", "properties": {
+			"region": "", "systemService": "AzureFrontDoor",
+			"addressPrefixes": ["147.243.0.0/16"]}}
+	]}`
+	c, err = ReadAzureServiceTags(strings.NewReader(azure))
+	assert.Nil(t, err)
+	assert.Equal(t, "20.38.98.0-20.38.98.255", c.Filter("", "eastus").String())
+	assert.Equal(t, "20.38.98.0-20.38.98.255", c.Filter("AzureStorage", "eastus").String())
+	assert.Equal(t, "147.243.0.0-147.243.255.255", c.Filter("AzureFrontDoor", "").String())
+	assert.Equal(t, "147.243.0.0-147.243.255.255", c.FilterTag("AzureFrontDoor.Backend").String())
+	assert.Equal(t, []string{"AzureFrontDoor", "AzureStorage"}, c.Services())
+	assert.Equal(t, []string{"AzureFrontDoor.Backend", "Storage.EastUS"}, c.Tags())
+
+	cf := "173.245.48.0/20\n103.21.244.0/22\n\n2400:cb00::/32\n::ffff:104.16.0.0/109\n::ffff:0.0.0.0/95\n"
+	c, err = ReadCloudflareRanges(strings.NewReader(cf))
+	assert.Nil(t, err)
+	assert.Len(t, c.IPv4, 3)
+	assert.Len(t, c.IPv6, 2)
+	assert.Equal(t, "104.16.0.0/13", c.IPv4[2].Prefix.String())
+	assert.Equal(t, "103.21.244.0-103.21.247.255\n104.16.0.0-104.23.255.255\n173.245.48.0-173.245.63.255", c.Filter("cloudflare", "").String())
+
+	_, err = ReadCloudflareRanges(strings.NewReader("173.245.48.0/33\n"))
+	assert.Error(t, err)
+	_, err = ReadAWSRanges(strings.NewReader("{"))
+	assert.Error(t, err)
+	_, err = LoadAWSRanges("/nonexistent/ip-ranges.json")
+	assert.Error(t, err)
+}
+
+func TestMergeRanges(t *testing.T) {
+	input := `# blocklist
+10.0.0.5
+10.0.0.0/30
+192.168.0.0/24
+10.0.0.4 # adjacent to 10.0.0.0/30
+172.16.0.1-172.16.0.10
+
+192.168.0.128/25
+172.16.0.11
+255.255.255.255
+10.0.0.7
+`
+	expected := "10.0.0.0-10.0.0.5\n10.0.0.7-10.0.0.7\n172.16.0.1-172.16.0.11\n192.168.0.0-192.168.0.255\n255.255.255.255-255.255.255.255\n"
+
+	// in memory
+	out := &strings.Builder{}
+	assert.Nil(t, MergeRangesTo(strings.NewReader(input), out, nil))
+	assert.Equal(t, expected, out.String())
+
+	// spill each 2 ranges to temporary files
+	tmpDir, err := ioutil.TempDir("", "cidr32-test-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+	out.Reset()
+	assert.Nil(t, MergeRangesTo(strings.NewReader(input), out, &MergeOptions{MemoryLimit: 2 * 8, TempDir: tmpDir}))
+	assert.Equal(t, expected, out.String())
+	files, _ := ioutil.ReadDir(tmpDir)
+	assert.Len(t, files, 0)
+
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, fmt.Sprintf("10.0.%d.%d", (999-i)/256, (999-i)%256))
+	}
+	rl := IPRangeList{}
+	err = MergeRanges(strings.NewReader(strings.Join(lines, "\n")), func(r *IPRange) error {
+		rl = append(rl, *r)
+		return nil
+	}, &MergeOptions{MemoryLimit: 10 * 8, TempDir: tmpDir})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.0-10.0.3.231", rl.String())
+
+	// 1000 spilled chunks are merged by levels, not all at once
+	rl = IPRangeList{}
+	err = MergeRanges(strings.NewReader(strings.Join(lines, "\n")), func(r *IPRange) error {
+		files, _ := ioutil.ReadDir(tmpDir)
+		assert.True(t, len(files) < mergeFanIn*2)
+		rl = append(rl, *r)
+		return nil
+	}, &MergeOptions{MemoryLimit: 8, TempDir: tmpDir})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.0-10.0.3.231", rl.String())
+	files, _ = ioutil.ReadDir(tmpDir)
+	assert.Len(t, files, 0)
+
+	// memory is not taken before the input arrives
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	assert.Nil(t, MergeRangesTo(strings.NewReader("10.0.0.1\n"), ioutil.Discard, nil))
+	runtime.ReadMemStats(&after)
+	assert.True(t, after.TotalAlloc-before.TotalAlloc < 1<<20)
+
+	err = MergeRangesTo(strings.NewReader("10.0.0.1\n10.0.0.x\n"), out, nil)
+	assert.EqualError(t, err, "line 2 '10.0.0.x': Can't parse range '10.0.0.x': addr '10.0.0.x' wrong")
+
+	// files, the last line may have no line break
+	a, b := tmpDir+"/a.txt", tmpDir+"/b.txt"
+	ioutil.WriteFile(a, []byte("10.0.0.5\n10.0.0.1"), 0644)
+	ioutil.WriteFile(b, []byte("10.0.0.2\n10.0.0.0/30\n"), 0644)
+	out.Reset()
+	assert.Nil(t, MergeFilesTo([]string{a, b}, out, nil))
+	assert.Equal(t, "10.0.0.0-10.0.0.3\n10.0.0.5-10.0.0.5\n", out.String())
+	// line of the wrong file is reported
+	ioutil.WriteFile(b, []byte("# comment\n10.0.0.x\n"), 0644)
+	err = MergeFilesTo([]string{a, b}, out, nil)
+	assert.EqualError(t, err, b+": line 2 '10.0.0.x': Can't parse range '10.0.0.x': addr '10.0.0.x' wrong")
+	assert.Equal(t, 2, err.(*FeedError).Line)
+	assert.Error(t, MergeFilesTo([]string{tmpDir + "/absent.txt"}, out, nil))
+}
+
+func TestBinaryEncoding(t *testing.T) {
+	r1, _ := NewRange("10.0.0.0/24")
+	r2, _ := NewRange("10.0.2.5-10.0.2.9")
+	r3, _ := NewRange("0.0.0.0")
+	r4, _ := NewRange("255.255.255.0/24")
+	rl := IPRangeList{*r2, *r4, *r1, *r3}
+
+	data, err := rl.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("C32\x01\x01\x04"), data[:6])
+	assert.True(t, len(data) < len(rl.String())/2)
+
+	var decoded IPRangeList
+	assert.Nil(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, rl.Arranged(), decoded)
+
+	enc, err := NewEncodedRangeList(data)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, enc.Len())
+	assert.True(t, enc.Contains(IPtoUint32(net.ParseIP("10.0.2.9"))))
+	assert.True(t, enc.Contains(IPtoUint32(net.ParseIP("255.255.255.255"))))
+	assert.False(t, enc.Contains(IPtoUint32(net.ParseIP("10.0.2.10"))))
+	assert.False(t, enc.Contains(IPtoUint32(net.ParseIP("0.0.0.1"))))
+	n := 0
+	enc.Each(func(r IPRange) bool {
+		n++
+		return n < 2
+	})
+	assert.Equal(t, 2, n)
+
+	// corrupted data
+	broken := append([]byte{}, data...)
+	broken[7] ^= 0xff
+	assert.Error(t, decoded.UnmarshalBinary(broken))
+	assert.Error(t, decoded.UnmarshalBinary(data[:5]))
+	assert.Error(t, decoded.UnmarshalBinary(nil))
+	assert.Equal(t, rl.Arranged(), decoded)
+
+	// empty list
+	data, err = IPRangeList{}.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Nil(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, IPRangeList{}, decoded)
+
+	ips := NewIPList([]string{"10.0.0.9", "10.0.0.1", "192.168.1.1", "10.0.0.2"})
+	data, err = ips.MarshalBinary()
+	assert.Nil(t, err)
+	var decodedIPs IPList
+	assert.Nil(t, decodedIPs.UnmarshalBinary(data))
+	assert.Equal(t, *ips, decodedIPs)
+	// wrong kind
+	assert.Error(t, decoded.UnmarshalBinary(data))
+	// duplicated addresses, i.e. zero delta, with valid checksum
+	crafted := appendBinaryHeader(nil, binaryKindIPList, 3)
+	for _, delta := range []uint64{5, 0, 1} {
+		crafted = appendUvarint(crafted, delta)
+	}
+	assert.Error(t, decodedIPs.UnmarshalBinary(appendChecksum(crafted)))
+	crafted = appendUvarint(appendBinaryHeader(nil, binaryKindIPList, 1), 0)
+	assert.Nil(t, decodedIPs.UnmarshalBinary(appendChecksum(crafted)))
+	assert.Equal(t, IPList{0}, decodedIPs)
+	// delta, which wraps the address around uint64, with valid checksum
+	crafted = appendBinaryHeader(nil, binaryKindIPList, 2)
+	for _, delta := range []uint64{100, ^uint64(0) - 49} {
+		crafted = appendUvarint(crafted, delta)
+	}
+	assert.Error(t, decodedIPs.UnmarshalBinary(appendChecksum(crafted)))
+	crafted = appendBinaryHeader(nil, binaryKindIPList, 2)
+	for _, delta := range []uint64{100, uint64(^uint32(0)) - 100} {
+		crafted = appendUvarint(crafted, delta)
+	}
+	assert.Nil(t, decodedIPs.UnmarshalBinary(appendChecksum(crafted)))
+	assert.Equal(t, IPList{100, ^uint32(0)}, decodedIPs)
+	for _, deltas := range [][]uint64{
+		{100, 10, ^uint64(0) - 59, 0}, // first of 2nd range wraps to 50
+		{100, 10, 5, ^uint64(0) - 10}, // last of 2nd range wraps to 104
+		{100, uint64(^uint32(0)) - 99},
+	} {
+		crafted = appendBinaryHeader(nil, binaryKindRangeList, len(deltas)/2)
+		for _, delta := range deltas {
+			crafted = appendUvarint(crafted, delta)
+		}
+		assert.Error(t, decoded.UnmarshalBinary(appendChecksum(crafted)), deltas)
+	}
+	crafted = appendBinaryHeader(nil, binaryKindRangeList, 1)
+	for _, delta := range []uint64{100, uint64(^uint32(0)) - 100} {
+		crafted = appendUvarint(crafted, delta)
+	}
+	assert.Nil(t, decoded.UnmarshalBinary(appendChecksum(crafted)))
+	assert.Equal(t, "0.0.0.100-255.255.255.255", decoded.String())
+}
+
+func TestSQL(t *testing.T) {
+	r1, _ := NewRange("10.0.0.0/24")
+	r2, _ := NewRange("10.0.2.5-10.0.2.9")
+	v, err := r1.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.0/24", v)
+	v, err = r2.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "[167772677,167772681]", v)
+
+	var rng IPRange
+	for _, c := range []struct {
+		src      interface{}
+		expected string
+	}{
+		{"10.0.0.0/24", "10.0.0.0-10.0.0.255"},
+		{[]byte("10.0.0.5/24"), "10.0.0.5-10.0.0.5"}, // inet host address
+		{"10.0.0.5", "10.0.0.5-10.0.0.5"},
+		{"[167772677,167772682)", "10.0.2.5-10.0.2.9"},
+		{"(167772676,167772681]", "10.0.2.5-10.0.2.9"},
+		{"10.0.2.5-10.0.2.9", "10.0.2.5-10.0.2.9"},
+		{"[0,4294967296)", "0.0.0.0-255.255.255.255"},
+		{"::ffff:1.2.3.0/120", "1.2.3.0-1.2.3.255"},
+		{"::ffff:1.2.3.4/120", "1.2.3.4-1.2.3.4"},
+	} {
+		assert.Nil(t, rng.Scan(c.src), c.src)
+		assert.Equal(t, c.expected, rng.String(), c.src)
+	}
+	for _, src := range []interface{}{nil, 42, "[1,)", "[5,1]", "[-1,5]", "fe80::/64", "::ffff:1.2.3.0/90", "wrong"} {
+		assert.Error(t, rng.Scan(src), src)
+	}
+
+	rl := IPRangeList{*r2, *r1}
+	v, err = rl.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "{10.0.0.0/24,10.0.2.5/32,10.0.2.6/31,10.0.2.8/31}", v)
+	var scanned IPRangeList
+	assert.Nil(t, scanned.Scan(v))
+	assert.Equal(t, rl.Arranged(), scanned.Arranged())
+	assert.Nil(t, scanned.Scan(`{10.0.0.0/24,"[167772677,167772682)"}`))
+	assert.Equal(t, IPRangeList{*r1, *r2}, scanned)
+	assert.Nil(t, scanned.Scan("{}"))
+	assert.Equal(t, IPRangeList{}, scanned)
+	assert.Nil(t, scanned.Scan(nil))
+	assert.Nil(t, scanned)
+	assert.Error(t, scanned.Scan("{NULL}"))
+	assert.Error(t, scanned.Scan("10.0.0.0/24"))
+
+	ips := NewIPList([]string{"10.0.0.9", "10.0.0.1"})
+	v, err = ips.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "{10.0.0.1,10.0.0.9}", v)
+	var scannedIPs IPList
+	assert.Nil(t, scannedIPs.Scan([]byte("{10.0.0.9/32,10.0.0.1,10.0.0.1/24}")))
+	assert.Equal(t, *ips, scannedIPs)
+	assert.Error(t, scannedIPs.Scan("{10.0.0.300}"))
+}
+
+func TestFlagValues(t *testing.T) {
+	var pool IPRange
+	var exclude IPRangeList
+	var pinned IPList
+	subnet := &CidrValue{ReserveNetBorders: true}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Var((*RangeValue)(&pool), "pool", "")
+	fs.Var((*RangeListValue)(&exclude), "exclude", "")
+	fs.Var((*IPListValue)(&pinned), "pinned", "")
+	fs.Var(subnet, "subnet", "")
+	err := fs.Parse([]string{
+		"--pool", "10.0.0.0/24",
+		"--exclude", "10.0.0.1,10.0.0.10-10.0.0.20",
+		"--exclude", "10.0.0.254",
+		"--pinned", "10.0.0.7,10.0.0.5",
+		"--pinned", "10.0.0.6,10.0.0.5",
+		"--subnet", "192.168.1.0/24",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.0-10.0.0.255", pool.String())
+	assert.Equal(t, "10.0.0.1-10.0.0.1\n10.0.0.10-10.0.0.20\n10.0.0.254-10.0.0.254", exclude.String())
+	assert.Equal(t, "10.0.0.1-10.0.0.1,10.0.0.10-10.0.0.20,10.0.0.254-10.0.0.254", fs.Lookup("exclude").Value.String())
+	assert.Equal(t, "10.0.0.5, 10.0.0.6, 10.0.0.7", pinned.String())
+	assert.Equal(t, "192.168.1.0/24", subnet.String())
+	rng, err := subnet.Range()
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.1.1-192.168.1.254", rng.String())
+
+	assert.Equal(t, "ipRange", (*RangeValue)(&pool).Type())
+	assert.Equal(t, "ipRangeList", (*RangeListValue)(&exclude).Type())
+	assert.Equal(t, "ipList", (*IPListValue)(&pinned).Type())
+	assert.Equal(t, "cidr", subnet.Type())
+
+	assert.Error(t, fs.Parse([]string{"--pool", "10.0.0.300"}))
+	assert.Error(t, fs.Parse([]string{"--exclude", "10.0.0.1,wrong"}))
+	assert.Error(t, fs.Parse([]string{"--pinned", "10.0.0.1,wrong"}))
+	assert.Error(t, fs.Parse([]string{"--subnet", "fe80::/64"}))
+	assert.Error(t, fs.Parse([]string{"--subnet", "::ffff:0.0.0.0/90"}))
+	assert.Nil(t, fs.Parse([]string{"--subnet", "::ffff:1.2.3.0/120"}))
+	assert.Equal(t, "1.2.3.0/24", subnet.String())
+	rng, err = subnet.Range()
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3.1-1.2.3.254", rng.String())
+	_, err = (&CidrValue{}).Range()
+	assert.Error(t, err)
+}
+
+// testClock -- manually driven Clock
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestLeaseManager(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)}
+	pool, _ := NewRange("10.0.0.1-10.0.0.3")
+	m := NewLeaseManager(IPRangeList{*pool}, clock)
+
+	l1, err := m.Lease("a", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1 a till 2026-10-18T12:01:00Z", l1.String())
+	l2, err := m.Lease("b", 2*time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2", Uint32toIP(l2.IP).String())
+	// existing lease is renewed
+	clock.Add(30 * time.Second)
+	l1, err = m.Lease("a", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1 a till 2026-10-18T12:01:30Z", l1.String())
+
+	l3, err := m.Lease("c", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.3", Uint32toIP(l3.IP).String())
+	_, err = m.Lease("d", time.Minute)
+	assert.Equal(t, ErrPoolExhausted, err)
+	assert.Len(t, m.Free(), 0)
+
+	l, ok := m.ByIP(l2.IP)
+	assert.True(t, ok)
+	assert.Equal(t, "b", l.Owner)
+	l, ok = m.ByOwner("c")
+	assert.True(t, ok)
+	assert.Equal(t, l3.IP, l.IP)
+
+	assert.Nil(t, m.Release("c"))
+	assert.Error(t, m.Release("c"))
+	assert.Equal(t, "10.0.0.3-10.0.0.3", m.Free().String())
+
+	// a expires at 12:01:30, b at 12:02:00
+	clock.Add(65 * time.Second)
+	_, ok = m.ByOwner("a")
+	assert.False(t, ok)
+	_, err = m.Renew("a", time.Minute)
+	assert.Error(t, err)
+	l2, err = m.Renew("b", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2 b till 2026-10-18T12:02:35Z", l2.String())
+	assert.Len(t, m.Leases(), 1)
+
+	expired := m.Sweep()
+	assert.Len(t, expired, 1)
+	assert.Equal(t, "a", expired[0].Owner)
+	assert.Equal(t, "10.0.0.1-10.0.0.1\n10.0.0.3-10.0.0.3", m.Free().String())
+
+	// concurrent leases get different addresses
+	pool, _ = NewRange("10.1.0.0/24")
+	m = NewLeaseManager(IPRangeList{*pool}, nil)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 256; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := m.Lease(fmt.Sprintf("owner-%d", i), time.Hour)
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, m.Leases(), 256)
+	assert.Len(t, m.Free(), 0)
+}
+
+func TestQuarantine(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)}
+	q := NewQuarantine(10*time.Minute, 2, clock)
+	q.Hold(1, 3)
+	q.Allocated()
+	clock.Add(time.Minute)
+	q.Hold(2)
+	assert.True(t, q.Has(1))
+	assert.Equal(t, "0.0.0.1-0.0.0.3", q.Ranges().String())
+	// 1 and 3 reached allocations limit
+	q.Allocated()
+	assert.False(t, q.Has(1))
+	assert.True(t, q.Has(2))
+	assert.Equal(t, QuarantineStats{Held: 1, Total: 3, Released: 2}, q.Stats())
+	// 2 reached time limit
+	clock.Add(10 * time.Minute)
+	assert.False(t, q.Has(2))
+	assert.Equal(t, IPList{}, *q.IPs())
+	assert.Equal(t, QuarantineStats{Held: 0, Total: 3, Released: 3}, q.Stats())
+}
+
+func TestLeaseManagerQuarantine(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)}
+	pool, _ := NewRange("10.0.0.1-10.0.0.3")
+	m := NewLeaseManager(IPRangeList{*pool}, clock)
+	q := NewQuarantine(5*time.Minute, 0, clock)
+	m.SetQuarantine(q)
+
+	l1, _ := m.Lease("a", time.Minute)
+	m.Lease("b", 10*time.Minute)
+	assert.Nil(t, m.Release("a"))
+	assert.True(t, q.Has(l1.IP))
+	assert.Equal(t, "10.0.0.3-10.0.0.3", m.Free().String())
+	l3, err := m.Lease("c", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.3", Uint32toIP(l3.IP).String())
+	_, err = m.Lease("d", time.Minute)
+	assert.Equal(t, ErrPoolExhausted, err)
+
+	// c expires and goes to quarantine, a ages out
+	clock.Add(5 * time.Minute)
+	assert.Equal(t, "10.0.0.1-10.0.0.1", m.Free().String())
+	assert.Equal(t, QuarantineStats{Held: 1, Total: 2, Released: 1}, q.Stats())
+	l4, err := m.Lease("d", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, l1.IP, l4.IP)
+}
+
+func TestStrategies(t *testing.T) {
+	p1, _ := NewRange("10.0.0.0-10.0.0.15")
+	p2, _ := NewRange("10.0.1.0-10.0.1.15")
+	pool := IPRangeList{*p1, *p2}
+	f1, _ := NewRange("10.0.0.2-10.0.0.9")
+	f2, _ := NewRange("10.0.0.12-10.0.0.14")
+	f3, _ := NewRange("10.0.1.4-10.0.1.15")
+	free := IPRangeList{*f1, *f2, *f3}
+
+	rng, err := FirstFit{}.Pick(pool, free, "x", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2-10.0.0.4", rng.String())
+	rng, err = FirstFit{}.Pick(pool, free, "x", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.1.4-10.0.1.13", rng.String())
+	_, err = FirstFit{}.Pick(pool, free, "x", 13)
+	assert.Equal(t, ErrPoolExhausted, err)
+	_, err = FirstFit{}.Pick(pool, free, "x", 0)
+	assert.Error(t, err)
+
+	rng, err = BestFit{}.Pick(pool, free, "x", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.12-10.0.0.14", rng.String())
+	rng, err = BestFit{}.Pick(pool, free, "x", 4)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2-10.0.0.5", rng.String())
+
+	rr := &RoundRobin{}
+	var picked []string
+	for i := 0; i < 5; i++ {
+		rng, err = rr.Pick(pool, free, "x", 4)
+		assert.Nil(t, err)
+		picked = append(picked, rng.String())
+	}
+	assert.Equal(t, []string{
+		"10.0.0.2-10.0.0.5", "10.0.0.6-10.0.0.9", "10.0.1.4-10.0.1.7", "10.0.1.8-10.0.1.11", "10.0.1.12-10.0.1.15",
+	}, picked)
+	rng, err = rr.Pick(pool, free, "x", 4)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2-10.0.0.5", rng.String()) // wrapped
+
+	random := NewRandomFit(42)
+	for i := 0; i < 100; i++ {
+		rng, err = random.Pick(pool, free, "x", 3)
+		assert.Nil(t, err)
+		assert.Empty(t, IPRangeList{*rng}.Subtract(free))
+	}
+	_, err = random.Pick(pool, free, "x", 13)
+	assert.Equal(t, ErrPoolExhausted, err)
+
+	// the same key gets the same address while it is free
+	s1, err := Sticky{}.Pick(pool, pool, "pod-a", 1)
+	assert.Nil(t, err)
+	s2, _ := Sticky{}.Pick(pool, pool, "pod-a", 1)
+	assert.Equal(t, s1, s2)
+	// and the next free one when it is taken
+	s3, _ := Sticky{}.Pick(pool, pool.Subtract(IPRangeList{*s1}), "pod-a", 1)
+	assert.NotEqual(t, s1, s3)
+	assert.True(t, pool.Contains(s3.First32()))
+	// allocator passes its own index of the pool to the strategy
+	alloc, err := NewAllocator(pool, Sticky{}).Allocate("pod-a")
+	assert.Nil(t, err)
+	assert.Equal(t, *s1, alloc.Range)
+
+	// wrong block sizes
+	for _, strategy := range []Strategy{FirstFit{}, BestFit{}, random, &RoundRobin{}, Sticky{}} {
+		for _, size := range []int{0, -3} {
+			_, err = strategy.Pick(pool, free, "x", size)
+			assert.Error(t, err)
+			_, err = NewAllocator(pool, strategy).AllocateBlock("x", size)
+			assert.Error(t, err)
+		}
+	}
+}
+
+func TestAllocator(t *testing.T) {
+	p, _ := NewRange("10.0.0.0-10.0.0.15")
+	a := NewAllocator(IPRangeList{*p}, nil)
+
+	a1, err := a.Allocate("a")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.0-10.0.0.0", a1.Range.String())
+	a2, err := a.AllocateBlock("b", 4)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1-10.0.0.4", a2.Range.String())
+	s, _ := NewRange("10.0.0.8-10.0.0.9")
+	a3, err := a.AllocateSpecific("a", s)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", a3.Owner)
+	_, err = a.AllocateSpecific("c", s)
+	assert.Error(t, err)
+	s, _ = NewRange("10.0.0.15-10.0.0.16")
+	_, err = a.AllocateSpecific("c", s)
+	assert.Error(t, err)
+
+	alloc, ok := a.Lookup(IPtoUint32(net.ParseIP("10.0.0.3")))
+	assert.True(t, ok)
+	assert.Equal(t, "b", alloc.Owner)
+	assert.Equal(t, "10.0.0.0-10.0.0.4\n10.0.0.8-10.0.0.9", a.Used().String())
+	assert.Equal(t, "10.0.0.5-10.0.0.7\n10.0.0.10-10.0.0.15", a.Free().String())
+	assert.Equal(t, 7, a.Stats().Used)
+
+	assert.Equal(t, 2, a.ReleaseOwner("a"))
+	assert.Nil(t, a.Release(IPtoUint32(net.ParseIP("10.0.0.2"))))
+	assert.Error(t, a.Release(IPtoUint32(net.ParseIP("10.0.0.2"))))
+	assert.Len(t, a.Allocations(), 0)
+	assert.Equal(t, "10.0.0.0-10.0.0.15", a.Free().String())
+
+	// best fit with quarantine
+	clock := &testClock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)}
+	a = NewAllocator(IPRangeList{*p}, BestFit{})
+	a.SetQuarantine(NewQuarantine(time.Minute, 0, clock))
+	a.AllocateBlock("a", 4)
+	a.AllocateBlock("b", 2)
+	a.AllocateBlock("c", 4)
+	assert.Nil(t, a.Release(IPtoUint32(net.ParseIP("10.0.0.4"))))
+	assert.Equal(t, "10.0.0.10-10.0.0.15", a.Free().String())
+	alloc, err = a.AllocateBlock("d", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.10-10.0.0.11", alloc.Range.String())
+	clock.Add(time.Minute)
+	alloc, err = a.AllocateBlock("e", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.4-10.0.0.5", alloc.Range.String())
+	assert.Equal(t, []string{"a", "e", "c", "d"}, func() (rv []string) {
+		for _, alloc := range a.Allocations() {
+			rv = append(rv, alloc.Owner)
+		}
+		return rv
+	}())
+}
+
+func TestHashToIP(t *testing.T) {
+	r1, _ := NewRange("10.0.0.0/24")
+	r2, _ := NewRange("10.0.1.0/24")
+	r3, _ := NewRange("10.0.2.0/23")
+	rl := IPRangeList{*r1, *r2}
+
+	ip, err := HashToIP("pod-a", rl, nil)
+	assert.Nil(t, err)
+	assert.True(t, rl.Contains(ip))
+	again, _ := HashToIP("pod-a", IPRangeList{*r2, *r1}, nil)
+	assert.Equal(t, ip, again) // order of ranges doesn't matter
+
+	// collision is resolved by probing
+	used := &IPList{}
+	used.Add(ip)
+	probed, err := HashToIP("pod-a", rl, used)
+	assert.Nil(t, err)
+	assert.NotEqual(t, ip, probed)
+	assert.True(t, rl.Contains(probed))
+
+	// adding a range remaps only keys, which are moved to the new range
+	moved, total := 0, 1000
+	for i := 0; i < total; i++ {
+		key := fmt.Sprintf("pod-%d", i)
+		before, _ := HashToIP(key, rl, nil)
+		after, _ := HashToIP(key, IPRangeList{*r1, *r2, *r3}, nil)
+		if before != after {
+			assert.True(t, r3.Contains(after), key)
+			moved++
+		}
+	}
+	// r3 has a half of addresses
+	assert.InDelta(t, total/2, moved, float64(total)/10)
+
+	// exhausted pool
+	small, _ := NewRange("10.0.0.1-10.0.0.2")
+	used = NewIPList([]string{"10.0.0.1", "10.0.0.2"})
+	_, err = HashToIP("pod-a", IPRangeList{*small}, used)
+	assert.Equal(t, ErrPoolExhausted, err)
+}
+
+func TestPositionalIndexing(t *testing.T) {
+	r1, _ := NewRange("10.0.0.0-10.0.0.9")
+	r2, _ := NewRange("10.0.1.0-10.0.1.4")
+	r3, _ := NewRange("10.0.2.0/30")
+	rl := IPRangeList{*r3, *r1, *r2}
+	idx := rl.Indexed()
+	assert.Equal(t, 19, idx.Len())
+	assert.Equal(t, rl.Arranged(), idx.Ranges())
+
+	for pos, expected := range map[int]string{
+		0: "10.0.0.0", 9: "10.0.0.9", 10: "10.0.1.0", 14: "10.0.1.4", 15: "10.0.2.0", 18: "10.0.2.3",
+	} {
+		ip, ok := idx.At(pos)
+		assert.True(t, ok)
+		assert.Equal(t, expected, Uint32toIP(ip).String())
+		assert.Equal(t, pos, idx.IndexOf(ip))
+	}
+	_, ok := idx.At(19)
+	assert.False(t, ok)
+	_, ok = idx.At(-1)
+	assert.False(t, ok)
+	assert.Equal(t, -1, idx.IndexOf(IPtoUint32(net.ParseIP("10.0.0.10"))))
+
+	ip, ok := idx.At(12)
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.1.2", Uint32toIP(ip).String())
+	assert.Equal(t, 12, idx.IndexOf(ip))
+
+	s, err := idx.Slice(8, 17)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.8-10.0.0.9\n10.0.1.0-10.0.1.4\n10.0.2.0-10.0.2.1", s.String())
+	s, err = idx.Slice(11, 13)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.1.1-10.0.1.2", s.String())
+	s, err = idx.Slice(0, 19)
+	assert.Nil(t, err)
+	assert.Equal(t, rl.Arranged(), s)
+	s, err = idx.Slice(5, 5)
+	assert.Nil(t, err)
+	assert.Len(t, s, 0)
+	_, err = idx.Slice(5, 20)
+	assert.Error(t, err)
+	_, err = idx.Slice(6, 5)
+	assert.Error(t, err)
+}
+
+func TestPartition(t *testing.T) {
+	r1, _ := NewRange("10.0.0.0-10.0.0.9")
+	r2, _ := NewRange("10.0.1.0/24")
+	r3, _ := NewRange("10.0.3.0-10.0.3.99")
+	rl := IPRangeList{*r2, *r3, *r1}
+
+	shards, err := rl.Partition(3)
+	assert.Nil(t, err)
+	assert.Len(t, shards, 3)
+	assert.Equal(t, []string{
+		"10.0.0.0-10.0.0.9\n10.0.1.0-10.0.1.111",
+		"10.0.1.112-10.0.1.233",
+		"10.0.1.234-10.0.1.255\n10.0.3.0-10.0.3.99",
+	}, []string{shards[0].String(), shards[1].String(), shards[2].String()})
+	joined := IPRangeList{}
+	for _, shard := range shards {
+		assert.InDelta(t, 122, shard.Capacity(), 1)
+		joined = append(joined, shard...)
+	}
+	assert.Equal(t, rl.Arranged(), joined.Arranged())
+
+	shards, err = rl.PartitionCidr(3, 26)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"10.0.0.0-10.0.0.9\n10.0.1.0-10.0.1.127",
+		"10.0.1.128-10.0.1.255",
+		"10.0.3.0-10.0.3.99",
+	}, []string{shards[0].String(), shards[1].String(), shards[2].String()})
+
+	// more shards than addresses
+	shards, err = IPRangeList{*r1}.PartitionCidr(4, 24)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, shards[0].Capacity()+shards[1].Capacity()+shards[2].Capacity()+shards[3].Capacity())
+
+	_, err = rl.Partition(0)
+	assert.Error(t, err)
+	_, err = rl.PartitionCidr(2, 33)
+	assert.Error(t, err)
+}
+
+func TestAllocatorStore(t *testing.T) {
+	p, _ := NewRange("10.0.0.0-10.0.0.15")
+	a := NewAllocator(IPRangeList{*p}, nil)
+	r, _ := NewRange("10.0.0.0-10.0.0.1")
+	assert.Nil(t, a.Reserve(r))
+	r, _ = NewRange("10.0.0.15-10.0.0.16")
+	assert.Error(t, a.Reserve(r))
+	alloc, err := a.AllocateBlock("vm1", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2-10.0.0.3", alloc.Range.String())
+	assert.Nil(t, a.Annotate(alloc.Range.First32(), map[string]string{"nic": "eth0"}))
+	a.Allocate("vm2")
+	assert.Equal(t, "10.0.0.5-10.0.0.15", a.Free().String())
+
+	dir, err := ioutil.TempDir("", "cidr32-store-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for _, store := range []Store{
+		NewMemoryStore(),
+		NewFileStore(dir + "/state.json"),
+		&FileStore{Path: dir + "/state.bin", Binary: true},
+	} {
+		_, err := store.Load()
+		assert.True(t, os.IsNotExist(err))
+		assert.Nil(t, SaveAllocator(store, a))
+		b, err := LoadAllocator(store, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, a.State(), b.State())
+		assert.Equal(t, "10.0.0.0-10.0.0.1", b.Reserved().String())
+		assert.Equal(t, "10.0.0.5-10.0.0.15", b.Free().String())
+		alloc, ok := b.Lookup(IPtoUint32(net.ParseIP("10.0.0.3")))
+		assert.True(t, ok)
+		assert.Equal(t, "vm1", alloc.Owner)
+		assert.Equal(t, "eth0", alloc.Metadata["nic"])
+	}
+	data, _ := ioutil.ReadFile(dir + "/state.json")
+	assert.Contains(t, string(data), `"range": "10.0.0.2-10.0.0.3"`)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 2)
+
+	// validation on load
+	state := a.State()
+	state.Pool = IPRangeList{*p}
+	r, _ = NewRange("10.0.0.16-10.0.0.17")
+	state.Allocations = append(state.Allocations, Allocation{Range: *r, Owner: "vm3"})
+	_, err = NewAllocatorFromState(state, nil)
+	assert.Error(t, err)
+	state = a.State()
+	r, _ = NewRange("10.0.0.3-10.0.0.4")
+	state.Allocations = append(state.Allocations, Allocation{Range: *r, Owner: "vm3"})
+	_, err = NewAllocatorFromState(state, nil)
+	assert.Error(t, err)
+	state = a.State()
+	r, _ = NewRange("10.0.0.1")
+	state.Allocations = append(state.Allocations, Allocation{Range: *r, Owner: "vm3"})
+	_, err = NewAllocatorFromState(state, nil)
+	assert.Error(t, err)
+}
+
+func TestHostLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cidr32-hostlocal-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	p, _ := NewRange("10.0.0.2-10.0.0.5")
+	h, err := NewHostLocal(dir+"/mynet", 0, IPRangeList{*p})
+	assert.Nil(t, err)
+
+	ip, err := h.Allocate("c1", "eth0")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2", Uint32toIP(ip).String())
+	data, _ := ioutil.ReadFile(dir + "/mynet/10.0.0.2")
+	assert.Equal(t, "c1\r\neth0", string(data))
+	data, _ = ioutil.ReadFile(dir + "/mynet/last_reserved_ip.0")
+	assert.Equal(t, "10.0.0.2", string(data))
+	assert.Nil(t, h.AllocateSpecific("c2", "eth0", IPtoUint32(net.ParseIP("10.0.0.4"))))
+	assert.Error(t, h.AllocateSpecific("c3", "eth0", IPtoUint32(net.ParseIP("10.0.0.4"))))
+	assert.Error(t, h.AllocateSpecific("c3", "eth0", IPtoUint32(net.ParseIP("10.0.0.6"))))
+
+	// round robin after the last reserved address, concurrent processes
+	// share the directory through the lock file
+	h2, _ := NewHostLocal(dir+"/mynet", 0, IPRangeList{*p})
+	ip, err = h2.Allocate("c3", "eth0")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.5", Uint32toIP(ip).String())
+	ip, err = h.Allocate("c4", "eth0")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.3", Uint32toIP(ip).String())
+	_, err = h.Allocate("c5", "eth0")
+	assert.Equal(t, ErrPoolExhausted, err)
+	used, _ := h.Used()
+	assert.Equal(t, "10.0.0.2-10.0.0.5", used.String())
+
+	n, err := h.ReleaseByID("c4", "eth0")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Nil(t, h.Release(IPtoUint32(net.ParseIP("10.0.0.5"))))
+	entries, _ := h.Allocations()
+	assert.Equal(t, []HostLocalEntry{
+		{IP: IPtoUint32(net.ParseIP("10.0.0.2")), ID: "c1", IfName: "eth0"},
+		{IP: IPtoUint32(net.ParseIP("10.0.0.4")), ID: "c2", IfName: "eth0"},
+	}, entries)
+
+	// inspection and repair of allocations made by somebody else
+	ioutil.WriteFile(dir+"/mynet/10.0.0.9", []byte("c6\r\neth0"), 0644)
+	ioutil.WriteFile(dir+"/mynet/10.0.0.3", []byte("c1\r\neth0"), 0644)
+	ioutil.WriteFile(dir+"/mynet/10.0.0.5", []byte(""), 0644)
+	ioutil.WriteFile(dir+"/mynet/fd00::1", []byte("c1\r\neth0"), 0644)
+	issues, err := h.Check()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.5 malformed", "10.0.0.2 duplicate", "10.0.0.3 duplicate", "10.0.0.9 outside pool"}, func() (rv []string) {
+		for _, issue := range issues {
+			rv = append(rv, issue.File+" "+issue.Kind.String())
+		}
+		return rv
+	}())
+	removed, err := h.Repair(func(id string) bool { return id != "c2" })
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.4", "10.0.0.5", "10.0.0.9"}, removed)
+	used, _ = h.Used()
+	assert.Equal(t, "10.0.0.2-10.0.0.3", used.String())
+}
+
+func TestAllocatorTx(t *testing.T) {
+	p, _ := NewRange("10.0.0.0-10.0.0.7")
+	a := NewAllocator(IPRangeList{*p}, nil)
+	a.Allocate("host")
+
+	// all or nothing
+	tx := a.Begin()
+	for i := 0; i < 5; i++ {
+		_, err := tx.Allocate("vm")
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, "10.0.0.1-10.0.0.7", a.Free().String())
+	_, err := tx.AllocateBlock("vm", 4)
+	assert.Equal(t, ErrPoolExhausted, err)
+	tx.Rollback()
+	assert.Equal(t, ErrTxDone, tx.Commit())
+	assert.Len(t, a.Allocations(), 1)
+	assert.Equal(t, "10.0.0.1-10.0.0.7", a.Free().String())
+
+	tx = a.Begin()
+	tx.AllocateBlock("vm", 2)
+	s, _ := NewRange("10.0.0.6")
+	_, err = tx.AllocateSpecific("vm", s)
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Release(IPtoUint32(net.ParseIP("10.0.0.0"))))
+	assert.Error(t, tx.Release(IPtoUint32(net.ParseIP("10.0.0.5"))))
+	assert.Len(t, tx.Allocations(), 2)
+	assert.Nil(t, tx.Commit())
+	tx.Rollback()
+	assert.Equal(t, "10.0.0.1-10.0.0.2\n10.0.0.6-10.0.0.6", a.Used().String())
+
+	// conflict with concurrent change of the same addresses
+	tx = a.Begin()
+	tx.Allocate("vm2")
+	a.Allocate("other")
+	assert.Equal(t, ErrTxConflict, tx.Commit())
+	assert.Equal(t, "10.0.0.0-10.0.0.2\n10.0.0.6-10.0.0.6", a.Used().String())
+	tx = a.Begin()
+	assert.Nil(t, tx.Release(IPtoUint32(net.ParseIP("10.0.0.0"))))
+	assert.Nil(t, a.Release(IPtoUint32(net.ParseIP("10.0.0.0"))))
+	assert.Equal(t, ErrTxConflict, tx.Commit())
+
+	// unrelated concurrent changes don't conflict
+	tx = a.Begin()
+	alloc, err := tx.Allocate("vm2")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.0-10.0.0.0", alloc.Range.String())
+	assert.Nil(t, tx.Release(IPtoUint32(net.ParseIP("10.0.0.6"))))
+	s, _ = NewRange("10.0.0.7")
+	a.AllocateSpecific("other", s)
+	assert.Nil(t, a.Release(IPtoUint32(net.ParseIP("10.0.0.1"))))
+	assert.Nil(t, tx.Commit())
+	assert.Equal(t, "10.0.0.0-10.0.0.0\n10.0.0.7-10.0.0.7", a.Used().String())
+	// restore 10.0.0.0-10.0.0.2 and 10.0.0.6 allocated
+	assert.Nil(t, a.Release(IPtoUint32(net.ParseIP("10.0.0.7"))))
+	for _, ip := range []string{"10.0.0.1-10.0.0.2", "10.0.0.6"} {
+		s, _ = NewRange(ip)
+		_, err = a.AllocateSpecific("vm", s)
+		assert.Nil(t, err)
+	}
+
+	// addresses, released in the transaction, are quarantined on commit
+	clock := &testClock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)}
+	a.SetQuarantine(NewQuarantine(time.Minute, 0, clock))
+	tx = a.Begin()
+	assert.Nil(t, tx.Release(IPtoUint32(net.ParseIP("10.0.0.6"))))
+	alloc, err = tx.Allocate("vm3")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.3-10.0.0.3", alloc.Range.String())
+	assert.Nil(t, tx.Commit())
+	assert.Equal(t, "10.0.0.4-10.0.0.5\n10.0.0.7-10.0.0.7", a.Free().String())
+
+	// metadata, changed by Annotate, is not shared with the transaction
+	tx = a.Begin()
+	defer tx.Rollback()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			a.Annotate(IPtoUint32(net.ParseIP("10.0.0.3")), map[string]string{"i": fmt.Sprint(i)})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		tx.Allocations()
+	}
+	<-done
+	for _, alloc := range tx.Allocations() {
+		assert.Nil(t, alloc.Metadata, alloc.Range.String())
+	}
+}
+
+func TestPoolTree(t *testing.T) {
+	r, _ := NewRange("10.0.0.0/22")
+	region := NewPool("eu", IPRangeList{*r}, nil)
+	zone, err := region.NewChild("a", 512, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "eu/a", zone.Path())
+	assert.Equal(t, "10.0.0.0-10.0.1.255", zone.Ranges().String())
+	_, err = region.NewChild("a", 16, nil)
+	assert.Error(t, err)
+	rack, err := zone.NewChild("r1", 256, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "eu/a/r1", rack.Path())
+	assert.Equal(t, rack, region.Child("a").Child("r1"))
+
+	// child space is accounted as used in the parent
+	alloc, _ := region.Allocator().Lookup(IPtoUint32(net.ParseIP("10.0.1.0")))
+	assert.Equal(t, "pool:a", alloc.Owner)
+	assert.Equal(t, "10.0.2.0-10.0.3.255", region.Allocator().Free().String())
+	assert.Equal(t, "10.0.1.0-10.0.1.255", zone.Allocator().Free().String())
+
+	// grow and shrink
+	block, err := rack.Grow(128)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.1.0-10.0.1.127", block.String())
+	assert.Equal(t, "10.0.0.0-10.0.1.127", rack.Ranges().String())
+	node, err := rack.Allocator().Allocate("node1")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.0-10.0.0.0", node.Range.String())
+	returned, err := rack.Shrink()
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.1.0-10.0.1.127", returned.String())
+	assert.Equal(t, "10.0.0.0-10.0.0.255", rack.Ranges().String())
+	assert.Equal(t, "10.0.1.0-10.0.1.255", zone.Allocator().Free().String())
+	returned, err = zone.Shrink()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(returned))
+
+	// blocks of children are managed by the tree only
+	assert.Error(t, zone.Allocator().Release(IPtoUint32(net.ParseIP("10.0.0.1"))))
+	assert.Equal(t, 0, zone.Allocator().ReleaseOwner("pool:r1"))
+	_, err = zone.Allocator().AllocateBlock("pool:r2", 16)
+	assert.Error(t, err)
+	tx := zone.Allocator().Begin()
+	assert.Error(t, tx.Release(IPtoUint32(net.ParseIP("10.0.0.1"))))
+	tx.Rollback()
+	assert.Equal(t, "10.0.0.0-10.0.0.255", zone.Allocator().Used().String())
+
+	// parent refuses to shrink below what children hold
+	assert.Error(t, region.RemoveRange(r))
+	assert.Error(t, zone.RemoveChild("r1"))
+	assert.Nil(t, rack.Allocator().Release(node.Range.First32()))
+	assert.Nil(t, zone.RemoveChild("r1"))
+	assert.Len(t, zone.Children(), 0)
+	_, err = rack.Grow(16)
+	assert.Error(t, err)
+	assert.Nil(t, region.RemoveChild("a"))
+	assert.Nil(t, region.RemoveRange(r))
+	assert.Equal(t, 0, len(region.Ranges()))
+}
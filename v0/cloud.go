@@ -0,0 +1,243 @@
+package cidr32
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// CloudPrefix -- prefix from the cloud provider IP ranges document
+type CloudPrefix struct {
+	Prefix  *net.IPNet
+	Service string
+	Region  string
+	// Tag -- name of the Azure service tag, i.e. `Storage.EastUS`.
+	// Empty for other providers
+	Tag string
+}
+
+// CloudRanges -- prefixes from the cloud provider IP ranges document.
+// IPv6 prefixes can't be converted to IPRange, so they are exposed separately.
+// IPv4-mapped IPv6 prefixes are converted to IPv4 ones
+type CloudRanges struct {
+	IPv4 []CloudPrefix
+	IPv6 []CloudPrefix
+}
+
+// Filter -- returns arranged list of IPv4 prefixes of given service and region.
+// Empty service or region matches any. Comparison is case-insensitive
+func (c *CloudRanges) Filter(service, region string) IPRangeList {
+	return c.filter(func(p CloudPrefix) bool {
+		return (service == "" || strings.EqualFold(service, p.Service)) && (region == "" || strings.EqualFold(region, p.Region))
+	})
+}
+
+// FilterTag -- returns arranged list of IPv4 prefixes of given Azure
+// service tag. Comparison is case-insensitive
+func (c *CloudRanges) FilterTag(tag string) IPRangeList {
+	return c.filter(func(p CloudPrefix) bool { return strings.EqualFold(tag, p.Tag) })
+}
+
+func (c *CloudRanges) filter(match func(CloudPrefix) bool) IPRangeList {
+	rv := IPRangeList{}
+	for _, p := range c.IPv4 {
+		if match(p) {
+			rng, _ := CidrToRange(p.Prefix, false)
+			rv = append(rv, *rng)
+		}
+	}
+	return rv.Arranged()
+}
+
+// Services -- returns sorted list of services of all prefixes
+func (c *CloudRanges) Services() []string {
+	return c.uniq(func(p CloudPrefix) string { return p.Service })
+}
+
+// Regions -- returns sorted list of regions of all prefixes
+func (c *CloudRanges) Regions() []string {
+	return c.uniq(func(p CloudPrefix) string { return p.Region })
+}
+
+// Tags -- returns sorted list of Azure service tags of all prefixes
+func (c *CloudRanges) Tags() []string {
+	return c.uniq(func(p CloudPrefix) string { return p.Tag })
+}
+
+func (c *CloudRanges) uniq(field func(CloudPrefix) string) []string {
+	rv := []string{}
+	for _, list := range [][]CloudPrefix{c.IPv4, c.IPv6} {
+		for _, p := range list {
+			if v := field(p); v != "" && !containsString(rv, v) {
+				rv = append(rv, v)
+			}
+		}
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func (c *CloudRanges) add(prefix, service, region, tag string) error {
+	_, cidr, err := net.ParseCIDR(strings.TrimSpace(prefix))
+	if err != nil {
+		return fmt.Errorf("Can't parse cloud prefix '%s': %s", prefix, err)
+	}
+	p := CloudPrefix{Prefix: cidr, Service: service, Region: region, Tag: tag}
+	// IPv4-mapped IPv6 prefixes are stored as IPv4 ones
+	if v4, err := cidr4(cidr); err == nil {
+		p.Prefix = v4
+		c.IPv4 = append(c.IPv4, p)
+	} else {
+		c.IPv6 = append(c.IPv6, p)
+	}
+	return nil
+}
+
+func newCloudRanges() *CloudRanges {
+	return &CloudRanges{
+		IPv4: []CloudPrefix{},
+		IPv6: []CloudPrefix{},
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// ReadAWSRanges -- parse AWS `ip-ranges.json` document
+func ReadAWSRanges(r io.Reader) (*CloudRanges, error) {
+	var doc struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+			Region     string `json:"region"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("Can't parse AWS IP ranges: %s", err)
+	}
+	rv := newCloudRanges()
+	for _, p := range doc.Prefixes {
+		if err := rv.add(p.IPPrefix, p.Service, p.Region, ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range doc.IPv6Prefixes {
+		if err := rv.add(p.IPv6Prefix, p.Service, p.Region, ""); err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+// ReadGCPRanges -- parse GCP `cloud.json` document.
+// Scope of the prefix is used as region
+func ReadGCPRanges(r io.Reader) (*CloudRanges, error) {
+	var doc struct {
+		Prefixes []struct {
+			IPv4Prefix string `json:"ipv4Prefix"`
+			IPv6Prefix string `json:"ipv6Prefix"`
+			Service    string `json:"service"`
+			Scope      string `json:"scope"`
+		} `json:"prefixes"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("Can't parse GCP IP ranges: %s", err)
+	}
+	rv := newCloudRanges()
+	for _, p := range doc.Prefixes {
+		prefix := p.IPv4Prefix
+		if prefix == "" {
+			prefix = p.IPv6Prefix
+		}
+		if err := rv.add(prefix, p.Service, p.Scope, ""); err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+// ReadAzureServiceTags -- parse Azure Service Tags JSON document.
+// System service (i.e. `AzureStorage`) is used as service,
+// name of the service tag (i.e. `Storage.EastUS`) is exposed as Tag
+func ReadAzureServiceTags(r io.Reader) (*CloudRanges, error) {
+	var doc struct {
+		Values []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				Region          string   `json:"region"`
+				SystemService   string   `json:"systemService"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("Can't parse Azure service tags: %s", err)
+	}
+	rv := newCloudRanges()
+	for _, v := range doc.Values {
+		for _, prefix := range v.Properties.AddressPrefixes {
+			if err := rv.add(prefix, v.Properties.SystemService, v.Properties.Region, v.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rv, nil
+}
+
+// ReadCloudflareRanges -- parse Cloudflare text list (`ips-v4`, `ips-v6`),
+// which contains prefix per line. Service is "cloudflare", region is empty
+func ReadCloudflareRanges(r io.Reader) (*CloudRanges, error) {
+	rv := newCloudRanges()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			if err := rv.add(line, "cloudflare", "", ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// LoadAWSRanges -- parse AWS `ip-ranges.json` file
+func LoadAWSRanges(path string) (*CloudRanges, error) {
+	return loadCloudRanges(path, ReadAWSRanges)
+}
+
+// LoadGCPRanges -- parse GCP `cloud.json` file
+func LoadGCPRanges(path string) (*CloudRanges, error) {
+	return loadCloudRanges(path, ReadGCPRanges)
+}
+
+// LoadAzureServiceTags -- parse Azure Service Tags JSON file
+func LoadAzureServiceTags(path string) (*CloudRanges, error) {
+	return loadCloudRanges(path, ReadAzureServiceTags)
+}
+
+// LoadCloudflareRanges -- parse Cloudflare text list file
+func LoadCloudflareRanges(path string) (*CloudRanges, error) {
+	return loadCloudRanges(path, ReadCloudflareRanges)
+}
+
+func loadCloudRanges(path string, read func(io.Reader) (*CloudRanges, error)) (*CloudRanges, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return read(f)
+}
@@ -0,0 +1,195 @@
+package cidr32
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FeedFormat -- format of IP list (blocklist, threat feed)
+type FeedFormat int
+
+const (
+	// FeedPlain -- address, range or CIDR per line, `#` comments
+	FeedPlain FeedFormat = iota
+	// FeedFireHOL -- FireHOL `.netset` and `.ipset` files
+	FeedFireHOL
+	// FeedSpamhaus -- Spamhaus DROP/EDROP: `CIDR ; SBL id`, `;` comments
+	FeedSpamhaus
+	// FeedP2P -- P2P/PeerGuardian: `name:first-last`, `#` comments
+	FeedP2P
+	// FeedDShield -- DShield block list: tab separated
+	// `start end netblock attacks name country email`, `#` comments
+	FeedDShield
+)
+
+func (f FeedFormat) String() string {
+	switch f {
+	case FeedPlain:
+		return "plain"
+	case FeedFireHOL:
+		return "firehol"
+	case FeedSpamhaus:
+		return "spamhaus"
+	case FeedP2P:
+		return "p2p"
+	case FeedDShield:
+		return "dshield"
+	}
+	return fmt.Sprintf("FeedFormat(%d)", int(f))
+}
+
+// FeedEntry -- range from the feed with label (SBL id, name, etc...)
+// if the format has it
+type FeedEntry struct {
+	Range IPRange
+	Label string
+	Line  int
+}
+
+// FeedError -- error of the feed line. File is empty for
+// the single input, i.e. reader of ReadFeed or MergeRanges
+type FeedError struct {
+	File string
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *FeedError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s: line %d '%s': %s", e.File, e.Line, e.Text, e.Err)
+	}
+	return fmt.Sprintf("line %d '%s': %s", e.Line, e.Text, e.Err)
+}
+
+// FeedErrors -- errors of wrong lines of the feed
+type FeedErrors []*FeedError
+
+func (e FeedErrors) Error() string {
+	rv := make([]string, len(e))
+	for i, err := range e {
+		rv[i] = err.Error()
+	}
+	return strings.Join(rv, "\n")
+}
+
+// ReadFeed -- parse the feed in given format.
+// Empty lines, comments and headers are skipped. Wrong lines are skipped and reported by FeedErrors, other entries are returned
+func ReadFeed(r io.Reader, format FeedFormat) ([]FeedEntry, error) {
+	var parse func(string) (*IPRange, string, error)
+	comments := "#"
+	switch format {
+	case FeedPlain, FeedFireHOL:
+		parse = parsePlainFeedLine
+	case FeedSpamhaus:
+		parse, comments = parseSpamhausFeedLine, ";"
+	case FeedP2P:
+		parse = parseP2PFeedLine
+	case FeedDShield:
+		parse = parseDShieldFeedLine
+	default:
+		return nil, fmt.Errorf("Unsupported feed format %s", format)
+	}
+
+	rv := []FeedEntry{}
+	var errs FeedErrors
+	in := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return rv, err
+		}
+		text := strings.TrimSpace(line)
+		if text != "" && !strings.HasPrefix(text, comments) {
+			if rng, label, e := parse(text); e != nil {
+				errs = append(errs, &FeedError{Line: n, Text: text, Err: e})
+			} else if rng != nil {
+				rv = append(rv, FeedEntry{Range: *rng, Label: label, Line: n})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return rv, errs
+	}
+	return rv, nil
+}
+
+// ReadFeedList -- parse the feed and returns arranged list of its ranges.
+// Wrong lines are reported like ReadFeed does
+func ReadFeedList(r io.Reader, format FeedFormat) (IPRangeList, error) {
+	entries, err := ReadFeed(r, format)
+	rv := make(IPRangeList, len(entries))
+	for i := range entries {
+		rv[i] = entries[i].Range
+	}
+	return rv.Arranged(), err
+}
+
+// ReadFeedMap -- parse the feed and returns map of ranges to labels.
+// `source` is used as label for entries without it.
+// Wrong lines are reported like ReadFeed does
+func ReadFeedMap(r io.Reader, format FeedFormat, source string) (*IPRangeMap, error) {
+	entries, err := ReadFeed(r, format)
+	rv := NewIPRangeMap()
+	for i := len(entries) - 1; i >= 0; i-- {
+		// reverse order, because first entry should win for overlapped ranges
+		label := entries[i].Label
+		if label == "" {
+			label = source
+		}
+		rv.Set(&entries[i].Range, label)
+	}
+	return rv, err
+}
+
+// parsePlainFeedLine -- `A.B.C.D`, `A.B.C.D/N` or `A.B.C.D-E.F.G.H`
+// with optional trailing comment
+func parsePlainFeedLine(line string) (*IPRange, string, error) {
+	if n := strings.IndexAny(line, "#;"); n >= 0 {
+		line = line[:n]
+	}
+	rng, err := NewRange(line)
+	return rng, "", err
+}
+
+// parseSpamhausFeedLine -- `A.B.C.D/N ; SBLnnn`
+func parseSpamhausFeedLine(line string) (*IPRange, string, error) {
+	parts := strings.SplitN(line, ";", 2)
+	rng, err := NewRange(parts[0])
+	if len(parts) == 1 {
+		return rng, "", err
+	}
+	return rng, strings.TrimSpace(parts[1]), err
+}
+
+// parseP2PFeedLine -- `name:A.B.C.D-E.F.G.H`, name can contain colons
+func parseP2PFeedLine(line string) (*IPRange, string, error) {
+	n := strings.LastIndex(line, ":")
+	if n < 0 {
+		return nil, "", fmt.Errorf("Can't parse P2P line, ':' not found")
+	}
+	rng, err := NewRange(line[n+1:])
+	return rng, strings.TrimSpace(line[:n]), err
+}
+
+// parseDShieldFeedLine -- `start end netblock attacks name country email`
+func parseDShieldFeedLine(line string) (*IPRange, string, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < 3 {
+		return nil, "", fmt.Errorf("Can't parse DShield line, at least 3 fields expected")
+	}
+	if fields[0] == "Start" {
+		// header line
+		return nil, "", nil
+	}
+	rng, err := NewRange(fields[0] + "-" + fields[1])
+	if len(fields) < 5 {
+		return rng, "", err
+	}
+	return rng, strings.TrimSpace(fields[4]), err
+}
@@ -0,0 +1,146 @@
+package cidr32
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// IPSet types, supported by WriteIPSet
+const (
+	IPSetHashNet  = "hash:net"
+	IPSetBitmapIP = "bitmap:ip"
+)
+
+// bitmapIPMaxLen -- max amount of addresses in the `bitmap:ip` set
+const bitmapIPMaxLen = 65536
+
+// hashNetMaxElem -- default max amount of entries in the `hash:net` set
+const hashNetMaxElem = 65536
+
+// WriteIPSet -- write `ipset restore` script, which creates set `name`
+// with all addresses of the list.
+// `hash:net` set can contain only CIDRs, so the list is decomposed to them
+// (whole address space is written as two /1 networks), `maxelem` and
+// `hashsize` of the set are raised if there are more than 65536 CIDRs.
+// `bitmap:ip` set can contain ranges, but no more than 65536 addresses
+func WriteIPSet(w io.Writer, rl IPRangeList, name, setType string) error {
+	ranges := rl.Arranged()
+	var lines []string
+	switch setType {
+	case IPSetHashNet:
+		cidrs := []string{}
+		for _, cidr := range ranges.Cidrs() {
+			if n, _ := cidr.Mask.Size(); n == 0 {
+				// hash:net doesn't accept zero prefix length
+				cidrs = append(cidrs, newCidr(0, 1).String(), newCidr(1<<31, 1).String())
+				continue
+			}
+			cidrs = append(cidrs, cidr.String())
+		}
+		create := fmt.Sprintf("create %s %s family inet", name, setType)
+		if len(cidrs) > hashNetMaxElem {
+			// hashsize should be power of two
+			hashSize := 1
+			for hashSize < len(cidrs) {
+				hashSize <<= 1
+			}
+			create = fmt.Sprintf("%s hashsize %d maxelem %d", create, hashSize, len(cidrs))
+		}
+		lines = append(lines, create+" -exist")
+		for _, cidr := range cidrs {
+			lines = append(lines, fmt.Sprintf("add %s %s -exist", name, cidr))
+		}
+	case IPSetBitmapIP:
+		if len(ranges) == 0 {
+			return fmt.Errorf("Can't create %s set '%s' for empty range list", setType, name)
+		}
+		span := IPRange{i32: [2]uint32{ranges[0].First32(), ranges[len(ranges)-1].Last32()}}
+		if span.Len() > bitmapIPMaxLen {
+			return fmt.Errorf("Can't create %s set '%s': range %s is larger than %d addresses", setType, name, &span, bitmapIPMaxLen)
+		}
+		lines = append(lines, fmt.Sprintf("create %s %s range %s -exist", name, setType, &span))
+		for i := range ranges {
+			lines = append(lines, fmt.Sprintf("add %s %s -exist", name, firewallAddr(&ranges[i])))
+		}
+	default:
+		return fmt.Errorf("Unsupported ipset type '%s'", setType)
+	}
+	return writeLines(w, lines)
+}
+
+// WriteNftSet -- write nftables table `family table` with interval set `name`,
+// which contains all addresses of the list. Result can be loaded by `nft -f`
+func WriteNftSet(w io.Writer, rl IPRangeList, family, table, name string) error {
+	ranges := rl.Arranged()
+	elements := make([]string, len(ranges))
+	for i := range ranges {
+		elements[i] = firewallAddr(&ranges[i])
+	}
+	lines := []string{
+		fmt.Sprintf("table %s %s {", family, table),
+		fmt.Sprintf("\tset %s {", name),
+		"\t\ttype ipv4_addr",
+		"\t\tflags interval",
+	}
+	if len(elements) > 0 {
+		lines = append(lines, fmt.Sprintf("\t\telements = { %s }", strings.Join(elements, ", ")))
+	}
+	lines = append(lines, "\t}", "}")
+	return writeLines(w, lines)
+}
+
+// WriteIptables -- write iptables rules, which append to the `chain` a rule
+// with jump to `target` for each range of the list.
+// `direction` should be "src" or "dst"
+func WriteIptables(w io.Writer, rl IPRangeList, chain, direction, target string) error {
+	if direction != "src" && direction != "dst" {
+		return fmt.Errorf("Wrong iptables direction '%s', should be 'src' or 'dst'", direction)
+	}
+	ranges := rl.Arranged()
+	lines := make([]string, len(ranges))
+	for i := range ranges {
+		if cidr := ranges[i].Cidr(); cidr != nil {
+			lines[i] = fmt.Sprintf("-A %s -%c %s -j %s", chain, direction[0], firewallAddr(&ranges[i]), target)
+		} else {
+			lines[i] = fmt.Sprintf("-A %s -m iprange --%s-range %s -j %s", chain, direction, &ranges[i], target)
+		}
+	}
+	return writeLines(w, lines)
+}
+
+// WritePfTable -- write pf table file, which can be loaded by
+// `table <name> persist file "..."`. pf tables can contain only CIDRs,
+// so the list is decomposed to them
+func WritePfTable(w io.Writer, rl IPRangeList) error {
+	var lines []string
+	for _, cidr := range rl.Cidrs() {
+		if n, _ := cidr.Mask.Size(); n == 32 {
+			lines = append(lines, cidr.IP.String())
+		} else {
+			lines = append(lines, cidr.String())
+		}
+	}
+	return writeLines(w, lines)
+}
+
+// firewallAddr -- returns the shortest form of the range:
+// address, CIDR or `A.B.C.D-E.F.G.H`
+func firewallAddr(r *IPRange) string {
+	if r.Len() == 1 {
+		return r.First().String()
+	}
+	if cidr := r.Cidr(); cidr != nil {
+		return cidr.String()
+	}
+	return r.String()
+}
+
+func writeLines(w io.Writer, lines []string) error {
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package cidr32
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Values for https://godoc.org/flag#Value, which also have `Type()`
+// required by https://godoc.org/github.com/spf13/pflag#Value.
+// Usage:
+//
+//	var pool cidr32.IPRange
+//	var exclude cidr32.IPRangeList
+//	flag.Var((*cidr32.RangeValue)(&pool), "pool", "addresses pool")
+//	flag.Var((*cidr32.RangeListValue)(&exclude), "exclude", "excluded ranges")
+
+// RangeValue -- flag value for single IPRange in NewRange format
+type RangeValue IPRange
+
+func (v *RangeValue) String() string {
+	return (*IPRange)(v).String()
+}
+
+// Set -- parse the range
+func (v *RangeValue) Set(s string) error {
+	rng, err := NewRange(s)
+	if err != nil {
+		return err
+	}
+	*v = RangeValue(*rng)
+	return nil
+}
+
+// Type -- type name for pflag
+func (v *RangeValue) Type() string {
+	return "ipRange"
+}
+
+// RangeListValue -- flag value for IPRangeList. Flag can be repeated
+// and can contain comma-separated ranges in NewRange format
+type RangeListValue IPRangeList
+
+func (v *RangeListValue) String() string {
+	return strings.Join(IPRangeList(*v).Strings(), ",")
+}
+
+// Set -- parse comma-separated ranges and append them to the list
+func (v *RangeListValue) Set(s string) error {
+	var rv IPRangeList
+	for _, rangeS := range strings.Split(s, ",") {
+		rng, err := NewRange(rangeS)
+		if err != nil {
+			return err
+		}
+		rv = append(rv, *rng)
+	}
+	*v = append(*v, rv...)
+	return nil
+}
+
+// Type -- type name for pflag
+func (v *RangeListValue) Type() string {
+	return "ipRangeList"
+}
+
+// IPListValue -- flag value for IPList. Flag can be repeated
+// and can contain comma-separated addresses
+type IPListValue IPList
+
+func (v *IPListValue) String() string {
+	return strings.Join(IPList(*v).Strings(), ",")
+}
+
+// Set -- parse comma-separated addresses and add them to the list
+func (v *IPListValue) Set(s string) error {
+	ips, err := NewIPListStrict(strings.Split(s, ","))
+	if err != nil {
+		return err
+	}
+	list := IPList(*v)
+	list.Unique()
+	list.Add(*ips...)
+	*v = IPListValue(list)
+	return nil
+}
+
+// Type -- type name for pflag
+func (v *IPListValue) Type() string {
+	return "ipList"
+}
+
+// CidrValue -- flag value for CIDR with reservation policy
+// of network and broadcast addresses, see CidrToRange()
+type CidrValue struct {
+	Cidr              *net.IPNet
+	ReserveNetBorders bool
+}
+
+func (v *CidrValue) String() string {
+	if v.Cidr == nil {
+		return ""
+	}
+	return v.Cidr.String()
+}
+
+// Set -- parse IPv4 CIDR. IPv4-mapped IPv6 CIDR is converted to IPv4 one
+func (v *CidrValue) Set(s string) error {
+	_, cidr, err := net.ParseCIDR(strings.TrimSpace(s))
+	if err == nil {
+		cidr, err = cidr4(cidr)
+	}
+	if err != nil {
+		return fmt.Errorf("Can't parse CIDR '%s'", s)
+	}
+	v.Cidr = cidr
+	return nil
+}
+
+// Type -- type name for pflag
+func (v *CidrValue) Type() string {
+	return "cidr"
+}
+
+// Range -- returns range of the CIDR according to the reservation policy
+func (v *CidrValue) Range() (*IPRange, error) {
+	if v.Cidr == nil {
+		return nil, fmt.Errorf("CIDR is not set")
+	}
+	return CidrToRange(v.Cidr, v.ReserveNetBorders)
+}
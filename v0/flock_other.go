@@ -0,0 +1,19 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package cidr32
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// lockFile -- flock is not available, cross-process locking is not supported
+func lockFile(f *os.File) error {
+	return fmt.Errorf("Can't lock %s: file locking is not supported on %s", f.Name(), runtime.GOOS)
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,63 @@
+package cidr32
+
+import (
+	"net"
+)
+
+// Gap -- free hole inside the universe
+type Gap struct {
+	Range       IPRange
+	Size        int
+	LargestCidr *net.IPNet
+}
+
+// GapReport -- free space of the universe, which is not occupied by a range list
+type GapReport struct {
+	Universe IPRange
+	Gaps     []Gap
+	// Histogram -- amount of free aligned blocks by prefix length
+	// if each hole is decomposed to minimal list of CIDRs
+	Histogram map[int]int
+}
+
+// Gaps -- returns report about free holes of the universe,
+// which are not occupied by the list
+func (r IPRangeList) Gaps(universe *IPRange) *GapReport {
+	rv := &GapReport{
+		Universe:  *universe,
+		Gaps:      []Gap{},
+		Histogram: map[int]int{},
+	}
+	for _, rng := range r.Complement(universe) {
+		rng := rng
+		rv.Gaps = append(rv.Gaps, Gap{
+			Range:       rng,
+			Size:        rng.Len(),
+			LargestCidr: rng.LargestCidr(),
+		})
+		for _, cidr := range rng.Cidrs() {
+			n, _ := cidr.Mask.Size()
+			rv.Histogram[n]++
+		}
+	}
+	return rv
+}
+
+// Free -- returns amount of free addresses
+func (g *GapReport) Free() (rv int) {
+	for _, gap := range g.Gaps {
+		rv = rv + gap.Size
+	}
+	return rv
+}
+
+// Fit -- returns the first free aligned CIDR with given prefix length
+// or nil if there is no room for it
+func (g *GapReport) Fit(prefixLen int) *net.IPNet {
+	for _, gap := range g.Gaps {
+		if cidr := gap.Range.FirstCidr(prefixLen); cidr != nil {
+			return cidr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package cidr32
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// GrepOptions -- options of GrepStream
+type GrepOptions struct {
+	// Invert -- select lines without addresses inside the ranges
+	Invert bool
+	// Tag -- prefix selected lines by names of matched ranges
+	Tag bool
+	// Count -- don't write lines, only count matches
+	Count bool
+}
+
+// GrepStats -- result of GrepStream
+type GrepStats struct {
+	// Lines -- amount of scanned lines
+	Lines int
+	// Selected -- amount of selected lines
+	Selected int
+	// ByRange -- amount of lines, matched by each range name
+	ByRange map[string]int
+}
+
+// ParseNamedRanges -- returns map of ranges, which can be used by GrepStream.
+// Each spec is a range in NewRange format, optionally prefixed by `name=`.
+// The spec itself is used as the name if it is not given.
+func ParseNamedRanges(specs []string) (*IPRangeMap, error) {
+	rv := NewIPRangeMap()
+	for i := len(specs) - 1; i >= 0; i-- {
+		// reverse order, because first spec should win for overlapped ranges
+		name, rangeS := strings.TrimSpace(specs[i]), specs[i]
+		if n := strings.Index(rangeS, "="); n >= 0 {
+			name, rangeS = strings.TrimSpace(rangeS[:n]), rangeS[n+1:]
+		}
+		rng, err := NewRange(rangeS)
+		if err != nil {
+			return nil, err
+		}
+		rv.Set(rng, name)
+	}
+	return rv, nil
+}
+
+// GrepStream -- scan the text stream line by line, extract IPv4 addresses
+// and write to `w` lines, where an address falls inside one of the ranges
+// (or lines without such addresses if Invert is set).
+// Names of ranges are values of the map.
+func GrepStream(r io.Reader, w io.Writer, ranges *IPRangeMap, opts GrepOptions) (*GrepStats, error) {
+	rv := &GrepStats{
+		ByRange: map[string]int{},
+	}
+	in := bufio.NewReader(r)
+	out := bufio.NewWriter(w)
+	for {
+		line, err := in.ReadBytes('\n')
+		if len(line) > 0 {
+			rv.Lines++
+			names := grepLine(line, ranges)
+			for _, name := range names {
+				rv.ByRange[name]++
+			}
+			if (len(names) > 0) != opts.Invert {
+				rv.Selected++
+				if !opts.Count {
+					if e := grepWrite(out, line, names, opts.Tag); e != nil {
+						return rv, e
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return rv, err
+		}
+	}
+	return rv, out.Flush()
+}
+
+func grepWrite(w *bufio.Writer, line []byte, names []string, tag bool) error {
+	if tag && len(names) > 0 {
+		if _, err := fmt.Fprintf(w, "%s: ", strings.Join(names, ",")); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(line); err != nil {
+		return err
+	}
+	if line[len(line)-1] != '\n' {
+		return w.WriteByte('\n')
+	}
+	return nil
+}
+
+// grepLine -- returns unique names of ranges, which contain addresses of the line
+func grepLine(line []byte, ranges *IPRangeMap) (rv []string) {
+	for i := 0; i < len(line); {
+		if !isIPv4Char(line[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(line) && isIPv4Char(line[j]) {
+			j++
+		}
+		if ip, ok := parseIPv4Token(line[i:j]); ok {
+			if e, found := ranges.Lookup(ip); found {
+				name := fmt.Sprint(e.Value)
+				if !containsString(rv, name) {
+					rv = append(rv, name)
+				}
+			}
+		}
+		i = j
+	}
+	return rv
+}
+
+func isIPv4Char(c byte) bool {
+	return c == '.' || (c >= '0' && c <= '9')
+}
+
+// parseIPv4Token -- parse `A.B.C.D` token, surrounding dots are ignored.
+// Does not allocate
+func parseIPv4Token(b []byte) (rv uint32, ok bool) {
+	for len(b) > 0 && b[0] == '.' {
+		b = b[1:]
+	}
+	for len(b) > 0 && b[len(b)-1] == '.' {
+		b = b[:len(b)-1]
+	}
+	octets, digits, octet := 0, 0, uint32(0)
+	for i := 0; i <= len(b); i++ {
+		if i == len(b) || b[i] == '.' {
+			if digits == 0 || octet > 255 {
+				return 0, false
+			}
+			rv = rv<<8 | octet
+			octets, digits, octet = octets+1, 0, 0
+			continue
+		}
+		if digits == 3 {
+			return 0, false
+		}
+		octet = octet*10 + uint32(b[i]-'0')
+		digits++
+	}
+	return rv, octets == 4
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
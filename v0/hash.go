@@ -0,0 +1,63 @@
+package cidr32
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// HashToIP -- map the key (pod name, MAC, tenant ID...) to the stable address
+// inside the range list.
+// The range is chosen by weighted rendezvous hashing, where weight is the range
+// size and the range is identified by its edges, so adding or removing a range
+// remaps only keys, which are moved to or from that range. Inside the range
+// the address is chosen by hash of the key, collisions with `used` addresses
+// are resolved by linear probing, then by probing of the next preferred range.
+// returns ErrPoolExhausted if all addresses are used
+func HashToIP(key string, rl IPRangeList, used *IPList) (uint32, error) {
+	type candidate struct {
+		rng   IPRange
+		score float64
+		hash  uint64
+	}
+	candidates := make([]candidate, len(rl))
+	for i, rng := range rl {
+		h := keyHash(key, rng)
+		// u is uniform in (0,1), so -weight/ln(u) gives the highest score
+		// to each range with probability proportional to its weight
+		u := (float64(h>>11) + 0.5) / (1 << 53)
+		candidates[i] = candidate{rng: rng, score: -float64(rng.Len()) / math.Log(u), hash: h}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+
+	for _, c := range candidates {
+		size := uint64(c.rng.Len())
+		offset := mix64(c.hash) % size
+		for k := uint64(0); k < size; k++ {
+			ip := c.rng.First32() + uint32((offset+k)%size)
+			if used == nil || !used.Has(ip) {
+				return ip, nil
+			}
+		}
+	}
+	return 0, ErrPoolExhausted
+}
+
+// keyHash -- FNV-1a hash of the key and edges of the range
+func keyHash(key string, r IPRange) uint64 {
+	var edges [8]byte
+	binary.BigEndian.PutUint32(edges[:4], r.First32())
+	binary.BigEndian.PutUint32(edges[4:], r.Last32())
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write(edges[:])
+	return mix64(h.Sum64())
+}
+
+// mix64 -- splitmix64 finalizer, spreads bits of FNV hash
+func mix64(h uint64) uint64 {
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	return h ^ (h >> 31)
+}
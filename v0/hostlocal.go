@@ -0,0 +1,346 @@
+package cidr32
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CNI host-local IPAM on-disk layout: directory of the network contains
+// one file per allocated address, named by the address and containing
+// `<container id>\r\n<ifname>`, the `last_reserved_ip.<range id>` file
+// with the last allocated address and the `lock` file, which is flock-ed
+// by each process while it reads or changes allocations
+const (
+	hostLocalLockFile     = "lock"
+	hostLocalLastReserved = "last_reserved_ip."
+	hostLocalLineBreak    = "\r\n"
+)
+
+// HostLocalEntry -- address, allocated to the interface of the container.
+// IfName is empty for files, written by old plugin versions
+type HostLocalEntry struct {
+	IP     uint32
+	ID     string
+	IfName string
+}
+
+func (e *HostLocalEntry) String() string {
+	return fmt.Sprintf("%s %s %s", Uint32toIP(e.IP), e.ID, e.IfName)
+}
+
+// HostLocalIssueKind -- kind of the problem, found by HostLocal.Check
+type HostLocalIssueKind int
+
+const (
+	// HostLocalMalformed -- file name is not a canonical IPv4 address
+	// or file does not contain container id
+	HostLocalMalformed HostLocalIssueKind = iota
+	// HostLocalOutsidePool -- allocated address is outside the pool
+	HostLocalOutsidePool
+	// HostLocalDuplicate -- interface of the container owns several addresses
+	HostLocalDuplicate
+)
+
+func (k HostLocalIssueKind) String() string {
+	switch k {
+	case HostLocalOutsidePool:
+		return "outside pool"
+	case HostLocalDuplicate:
+		return "duplicate"
+	}
+	return "malformed"
+}
+
+// HostLocalIssue -- problem of the file `File` in the network directory.
+// Entry is filled as far as the file could be parsed
+type HostLocalIssue struct {
+	File  string
+	Kind  HostLocalIssueKind
+	Entry HostLocalEntry
+}
+
+// HostLocal -- allocator, compatible with the CNI host-local IPAM plugin.
+// Allocations are stored in the network directory, so several processes,
+// including the CNI plugin itself, can share the pool on one host.
+// Each operation holds flock on the `lock` file
+type HostLocal struct {
+	dir     string
+	rangeID int
+	pool    IPRangeList
+}
+
+// NewHostLocal -- returns allocator, which stores allocations in the
+// network directory `dir` (i.e. /var/lib/cni/networks/<network name>).
+// rangeID is the index of the range set in the plugin config, it is used
+// as suffix of the `last_reserved_ip` file. Directory is created if absent
+func NewHostLocal(dir string, rangeID int, pool IPRangeList) (*HostLocal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &HostLocal{
+		dir:     dir,
+		rangeID: rangeID,
+		pool:    pool.Arranged(),
+	}, nil
+}
+
+// Allocate -- allocate address to the interface of the container.
+// Address is searched round-robin after the last reserved one,
+// like the CNI plugin does
+func (h *HostLocal) Allocate(id, ifname string) (rv uint32, err error) {
+	err = h.withLock(func() error {
+		entries, issues, err := h.read()
+		if err != nil {
+			return err
+		}
+		// files of malformed allocations still occupy addresses
+		used := IPList{}
+		for i := range entries {
+			used = append(used, entries[i].IP)
+		}
+		for i := range issues {
+			used = append(used, issues[i].Entry.IP)
+		}
+		start := uint32(0)
+		if last, ok := h.lastReserved(); ok {
+			start = last + 1
+		}
+		rng, err := fitFrom(h.pool.Subtract(used.ToRangeList()), start, 1)
+		if err != nil {
+			return err
+		}
+		rv = rng.First32()
+		return h.reserve(rv, id, ifname)
+	})
+	return rv, err
+}
+
+// AllocateSpecific -- allocate given address to the interface of the container.
+// The address should be inside the pool and free
+func (h *HostLocal) AllocateSpecific(id, ifname string, ip uint32) error {
+	if !h.pool.Contains(ip) {
+		return fmt.Errorf("Can't allocate %s: outside the pool", Uint32toIP(ip))
+	}
+	return h.withLock(func() error {
+		return h.reserve(ip, id, ifname)
+	})
+}
+
+// Release -- release the address
+func (h *HostLocal) Release(ip uint32) error {
+	return h.withLock(func() error {
+		return os.Remove(h.path(ip))
+	})
+}
+
+// ReleaseByID -- release addresses of the interface of the container.
+// Empty ifname matches any interface. Returns amount of released addresses
+func (h *HostLocal) ReleaseByID(id, ifname string) (n int, err error) {
+	err = h.withLock(func() error {
+		entries, _, err := h.read()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.ID == id && (ifname == "" || e.IfName == "" || e.IfName == ifname) {
+				if err := os.Remove(h.path(e.IP)); err != nil {
+					return err
+				}
+				n = n + 1
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Allocations -- returns valid allocations sorted by address.
+// Files, which can't be parsed, are skipped, use Check to find them
+func (h *HostLocal) Allocations() (rv []HostLocalEntry, err error) {
+	err = h.withLock(func() error {
+		rv, _, err = h.read()
+		return err
+	})
+	return rv, err
+}
+
+// Used -- returns arranged list of allocated addresses
+func (h *HostLocal) Used() (IPRangeList, error) {
+	entries, err := h.Allocations()
+	if err != nil {
+		return nil, err
+	}
+	ips := make(IPList, len(entries))
+	for i := range entries {
+		ips[i] = entries[i].IP
+	}
+	return ips.ToRangeList(), nil
+}
+
+// Check -- returns problems of allocations: malformed files, addresses
+// outside the pool and interfaces, which own several addresses
+func (h *HostLocal) Check() (rv []HostLocalIssue, err error) {
+	err = h.withLock(func() error {
+		rv, err = h.check()
+		return err
+	})
+	return rv, err
+}
+
+// Repair -- remove files of malformed allocations and allocations outside
+// the pool. If `alive` is not nil, allocations of containers, for which
+// it returns false, are removed too. Returns removed files
+func (h *HostLocal) Repair(alive func(id string) bool) (rv []string, err error) {
+	err = h.withLock(func() error {
+		issues, err := h.check()
+		if err != nil {
+			return err
+		}
+		remove := map[string]bool{}
+		for _, issue := range issues {
+			if issue.Kind != HostLocalDuplicate {
+				remove[issue.File] = true
+			}
+		}
+		if alive != nil {
+			entries, _, err := h.read()
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if !alive(e.ID) {
+					remove[Uint32toIP(e.IP).String()] = true
+				}
+			}
+		}
+		for name := range remove {
+			if err := os.Remove(filepath.Join(h.dir, name)); err != nil {
+				return err
+			}
+			rv = append(rv, name)
+		}
+		return nil
+	})
+	sort.Strings(rv)
+	return rv, err
+}
+
+// -----------------------------------------------------------------------------
+
+func (h *HostLocal) path(ip uint32) string {
+	return filepath.Join(h.dir, Uint32toIP(ip).String())
+}
+
+func (h *HostLocal) withLock(fn func() error) error {
+	f, err := os.OpenFile(filepath.Join(h.dir, hostLocalLockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+	return fn()
+}
+
+// reserve -- create file of the allocation and update the last reserved address
+func (h *HostLocal) reserve(ip uint32, id, ifname string) error {
+	f, err := os.OpenFile(h.path(ip), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("Can't allocate %s: already allocated", Uint32toIP(ip))
+	} else if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strings.TrimSpace(id) + hostLocalLineBreak + ifname); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return ioutil.WriteFile(h.lastReservedPath(), []byte(Uint32toIP(ip).String()), 0644)
+}
+
+func (h *HostLocal) lastReservedPath() string {
+	return filepath.Join(h.dir, hostLocalLastReserved+strconv.Itoa(h.rangeID))
+}
+
+func (h *HostLocal) lastReserved() (uint32, bool) {
+	data, err := ioutil.ReadFile(h.lastReservedPath())
+	if err != nil {
+		return 0, false
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(data))).To4()
+	if ip == nil {
+		return 0, false
+	}
+	return IPtoUint32(ip), true
+}
+
+// read -- returns entries, sorted by address, and issues of malformed files
+func (h *HostLocal) read() (rv []HostLocalEntry, issues []HostLocalIssue, err error) {
+	files, err := ioutil.ReadDir(h.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	rv = []HostLocalEntry{}
+	issues = []HostLocalIssue{}
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || name == hostLocalLockFile || strings.HasPrefix(name, hostLocalLastReserved) {
+			continue
+		}
+		// other files, i.e. temporary ones, and IPv6 allocations are skipped
+		ip := net.ParseIP(name)
+		if ip == nil || ip.To4() == nil {
+			continue
+		}
+		e := HostLocalEntry{IP: IPtoUint32(ip.To4())}
+		data, err := ioutil.ReadFile(filepath.Join(h.dir, name))
+		if err != nil {
+			return nil, nil, err
+		}
+		lines := strings.SplitN(string(data), hostLocalLineBreak, 2)
+		e.ID = strings.TrimSpace(lines[0])
+		if len(lines) > 1 {
+			e.IfName = strings.TrimSpace(lines[1])
+		}
+		if e.ID == "" || name != Uint32toIP(e.IP).String() {
+			issues = append(issues, HostLocalIssue{File: name, Kind: HostLocalMalformed, Entry: e})
+			continue
+		}
+		rv = append(rv, e)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].IP < rv[j].IP })
+	return rv, issues, nil
+}
+
+func (h *HostLocal) check() ([]HostLocalIssue, error) {
+	entries, issues, err := h.read()
+	if err != nil {
+		return nil, err
+	}
+	owners := map[string]int{}
+	for _, e := range entries {
+		owners[e.ID+hostLocalLineBreak+e.IfName]++
+	}
+	for _, e := range entries {
+		name := Uint32toIP(e.IP).String()
+		if !h.pool.Contains(e.IP) {
+			issues = append(issues, HostLocalIssue{File: name, Kind: HostLocalOutsidePool, Entry: e})
+		}
+		if owners[e.ID+hostLocalLineBreak+e.IfName] > 1 {
+			issues = append(issues, HostLocalIssue{File: name, Kind: HostLocalDuplicate, Entry: e})
+		}
+	}
+	return issues, nil
+}
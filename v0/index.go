@@ -0,0 +1,85 @@
+package cidr32
+
+import (
+	"fmt"
+	"sort"
+)
+
+// IndexedRangeList -- arranged range list with cumulative offsets of ranges.
+// Positional operations (At, IndexOf, Slice) take O(log n).
+// Build it once by IPRangeList.Indexed() and reuse for repeated calls
+type IndexedRangeList struct {
+	ranges IPRangeList
+	// offsets -- position of the first address of each range
+	offsets []int
+	total   int
+}
+
+// Indexed -- returns indexed copy of the arranged list.
+// Arranging and indexing take O(n log n)
+func (r IPRangeList) Indexed() *IndexedRangeList {
+	rv := &IndexedRangeList{
+		ranges: r.Arranged(),
+	}
+	rv.offsets = make([]int, len(rv.ranges))
+	for i := range rv.ranges {
+		rv.offsets[i] = rv.total
+		rv.total = rv.total + rv.ranges[i].Len()
+	}
+	return rv
+}
+
+// Len -- returns amount of addresses
+func (x *IndexedRangeList) Len() int {
+	return x.total
+}
+
+// Ranges -- returns the arranged list
+func (x *IndexedRangeList) Ranges() IPRangeList {
+	return append(IPRangeList{}, x.ranges...)
+}
+
+// At -- returns n-th (from zero) address of the list
+func (x *IndexedRangeList) At(n int) (uint32, bool) {
+	if n < 0 || n >= x.total {
+		return 0, false
+	}
+	i := x.rangeAt(n)
+	return x.ranges[i].First32() + uint32(n-x.offsets[i]), true
+}
+
+// rangeAt -- returns index of the range, which contains n-th address
+func (x *IndexedRangeList) rangeAt(n int) int {
+	return sort.Search(len(x.offsets), func(i int) bool { return x.offsets[i] > n }) - 1
+}
+
+// IndexOf -- returns position of the address in the list or -1 if not found
+func (x *IndexedRangeList) IndexOf(ip uint32) int {
+	i := sort.Search(len(x.ranges), func(i int) bool { return x.ranges[i].Last32() >= ip })
+	if i < len(x.ranges) && x.ranges[i].Contains(ip) {
+		return x.offsets[i] + int(ip-x.ranges[i].First32())
+	}
+	return -1
+}
+
+// Slice -- returns list of addresses with positions in [from, to)
+func (x *IndexedRangeList) Slice(from, to int) (IPRangeList, error) {
+	if from < 0 || to > x.total || from > to {
+		return nil, fmt.Errorf("Wrong slice [%d:%d] of %d addresses", from, to, x.total)
+	}
+	rv := IPRangeList{}
+	if from == to {
+		return rv, nil
+	}
+	for i := x.rangeAt(from); i < len(x.ranges) && x.offsets[i] < to; i++ {
+		rng := x.ranges[i]
+		if from > x.offsets[i] {
+			rng.i32[0] = rng.First32() + uint32(from-x.offsets[i])
+		}
+		if end := x.offsets[i] + x.ranges[i].Len(); to < end {
+			rng.i32[1] = x.ranges[i].Last32() - uint32(end-to)
+		}
+		rv = append(rv, rng)
+	}
+	return rv, nil
+}
@@ -1,23 +1,44 @@
 package cidr32
 
 import (
+	"fmt"
 	"net"
 	"sort"
 	"strings"
 )
 
+// IPList -- sorted set of IPv4 addresses in the uint32 format
 type IPList []uint32
 
+// NewIPList -- returns sorted list of unique addresses.
+// Wrong and non-IPv4 addresses are silently skipped
 func NewIPList(ips []string) *IPList {
-	// rv := make(IPList, len(ips))
+	rv, _ := parseIPList(ips)
+	return rv
+}
+
+// NewIPListStrict -- returns sorted list of unique addresses
+// or error, which contains all wrong addresses
+func NewIPListStrict(ips []string) (*IPList, error) {
+	rv, wrong := parseIPList(ips)
+	if len(wrong) > 0 {
+		return nil, fmt.Errorf("Can't parse IP list, wrong addresses: '%s'", strings.Join(wrong, "', '"))
+	}
+	return rv, nil
+}
+
+func parseIPList(ips []string) (*IPList, []string) {
+	var wrong []string
 	rv := IPList{}
 	for _, ip := range ips {
-		if tmp := net.ParseIP(ip); tmp != nil {
+		if tmp := net.ParseIP(strings.TrimSpace(ip)); tmp != nil && tmp.To4() != nil {
 			rv = append(rv, IPtoUint32(tmp))
+		} else {
+			wrong = append(wrong, ip)
 		}
 	}
-	rv.Sort()
-	return &rv
+	rv.Unique()
+	return &rv, wrong
 }
 
 // ----------------------------------------------------------------------------
@@ -42,6 +63,189 @@ func (r IPList) Swap(i, j int) {
 	r[i], r[j] = r[j], r[i]
 }
 
+// Unique -- sort the list (if need) and remove duplicated addresses
+func (r *IPList) Unique() {
+	if !sort.IsSorted(*r) {
+		r.Sort()
+	}
+	rv := (*r)[:0]
+	for i, ip := range *r {
+		if i == 0 || ip != rv[len(rv)-1] {
+			rv = append(rv, ip)
+		}
+	}
+	*r = rv
+}
+
+// search -- returns position of the address in the sorted list
+// or position, where it should be inserted
+func (r IPList) search(ip uint32) int {
+	return sort.Search(len(r), func(i int) bool { return r[i] >= ip })
+}
+
+// Has -- returns true if the address is in the list.
+// Uses binary search, so the list should be sorted
+func (r IPList) Has(ip uint32) bool {
+	i := r.search(ip)
+	return i < len(r) && r[i] == ip
+}
+
+// Add -- insert addresses to the sorted list, keeps it sorted and unique.
+// returns amount of really added addresses
+func (r *IPList) Add(ips ...uint32) (n int) {
+	for _, ip := range ips {
+		i := r.search(ip)
+		if i < len(*r) && (*r)[i] == ip {
+			continue
+		}
+		*r = append(*r, 0)
+		copy((*r)[i+1:], (*r)[i:])
+		(*r)[i] = ip
+		n = n + 1
+	}
+	return n
+}
+
+// Remove -- remove addresses from the sorted list.
+// returns amount of really removed addresses
+func (r *IPList) Remove(ips ...uint32) (n int) {
+	for _, ip := range ips {
+		i := r.search(ip)
+		if i < len(*r) && (*r)[i] == ip {
+			*r = append((*r)[:i], (*r)[i+1:]...)
+			n = n + 1
+		}
+	}
+	return n
+}
+
+// normalized -- returns the list itself if it sorted and unique,
+// or sorted and unique copy otherwise
+func (r IPList) normalized() IPList {
+	for i := 1; i < len(r); i++ {
+		if r[i-1] >= r[i] {
+			rv := append(IPList{}, r...)
+			rv.Unique()
+			return rv
+		}
+	}
+	return r
+}
+
+// Union -- returns new list with addresses from both lists
+func (r IPList) Union(other IPList) *IPList {
+	a, b := r.normalized(), other.normalized()
+	rv := make(IPList, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			rv = append(rv, a[i])
+			i++
+		case a[i] > b[j]:
+			rv = append(rv, b[j])
+			j++
+		default:
+			rv = append(rv, a[i])
+			i, j = i+1, j+1
+		}
+	}
+	rv = append(rv, a[i:]...)
+	rv = append(rv, b[j:]...)
+	return &rv
+}
+
+// Intersect -- returns new list with addresses, which are present in the both lists
+func (r IPList) Intersect(other IPList) *IPList {
+	a, b := r.normalized(), other.normalized()
+	rv := IPList{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			rv = append(rv, a[i])
+			i, j = i+1, j+1
+		}
+	}
+	return &rv
+}
+
+// Difference -- returns new list with addresses, which are absent in the `other` list
+func (r IPList) Difference(other IPList) *IPList {
+	a, b := r.normalized(), other.normalized()
+	rv := IPList{}
+	j := 0
+	for _, ip := range a {
+		for j < len(b) && b[j] < ip {
+			j++
+		}
+		if j < len(b) && b[j] == ip {
+			continue
+		}
+		rv = append(rv, ip)
+	}
+	return &rv
+}
+
+// IntersectRanges -- returns new list with addresses, which are inside the range list
+func (r IPList) IntersectRanges(rl IPRangeList) *IPList {
+	rv := IPList{}
+	r.walkRanges(rl, func(ip uint32, inside bool) {
+		if inside {
+			rv = append(rv, ip)
+		}
+	})
+	return &rv
+}
+
+// DifferenceRanges -- returns new list with addresses, which are outside the range list
+func (r IPList) DifferenceRanges(rl IPRangeList) *IPList {
+	rv := IPList{}
+	r.walkRanges(rl, func(ip uint32, inside bool) {
+		if !inside {
+			rv = append(rv, ip)
+		}
+	})
+	return &rv
+}
+
+// UnionRanges -- returns arranged range list, which contains
+// the all addresses from the list and from the range list
+func (r IPList) UnionRanges(rl IPRangeList) IPRangeList {
+	rv := append(r.ToRangeList(), rl...)
+	return rv.Arranged()
+}
+
+// ToRangeList -- collapse runs of consecutive addresses to ranges.
+// returns sorted and glued range list
+func (r IPList) ToRangeList() IPRangeList {
+	rv := IPRangeList{}
+	for _, ip := range r.normalized() {
+		if n := len(rv) - 1; n >= 0 && rv[n].Last32() != ^uint32(0) && rv[n].Last32()+1 == ip {
+			rv[n].i32[1] = ip
+		} else {
+			rv = append(rv, IPRange{i32: [2]uint32{ip, ip}})
+		}
+	}
+	return rv
+}
+
+// walkRanges -- call `fn` for each address of the list in order
+// and report whether the address is inside the range list
+func (r IPList) walkRanges(rl IPRangeList, fn func(ip uint32, inside bool)) {
+	ranges := rl.Arranged()
+	j := 0
+	for _, ip := range r.normalized() {
+		for j < len(ranges) && ranges[j].Last32() < ip {
+			j++
+		}
+		fn(ip, j < len(ranges) && ranges[j].Contains(ip))
+	}
+}
+
 // Index -- seach IP and return it's index.
 // returns -1 if not found
 func (r IPList) Index(targetIP uint32) int {
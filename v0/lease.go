@@ -0,0 +1,223 @@
+package cidr32
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrPoolExhausted -- there are no free addresses in the pool
+var ErrPoolExhausted = errors.New("Pool is exhausted")
+
+// Clock -- source of current time, can be replaced in tests
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock -- Clock, which returns current system time
+var SystemClock Clock = systemClock{}
+
+// Lease -- address, leased by the owner till the expiration time
+type Lease struct {
+	IP      uint32
+	Owner   string
+	Expires time.Time
+}
+
+// Expired -- returns true if the lease is expired at given time
+func (l *Lease) Expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+func (l *Lease) String() string {
+	return fmt.Sprintf("%s %s till %s", Uint32toIP(l.IP), l.Owner, l.Expires.Format(time.RFC3339))
+}
+
+// LeaseManager -- DHCP-like manager of addresses leases from the pool.
+// Each owner can have one lease. Safe for concurrent use
+type LeaseManager struct {
+	mu      sync.Mutex
+	pool    IPRangeList
+	clock   Clock
+	byIP    map[uint32]*Lease
+	byOwner map[string]*Lease
+	leased  IPList
+	// quarantine -- optional quarantine of released and expired addresses
+	quarantine *Quarantine
+	strategy   Strategy
+}
+
+// NewLeaseManager -- returns manager of the pool.
+// SystemClock is used if clock is nil
+func NewLeaseManager(pool IPRangeList, clock Clock) *LeaseManager {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &LeaseManager{
+		pool:     pool.Arranged(),
+		clock:    clock,
+		byIP:     map[uint32]*Lease{},
+		byOwner:  map[string]*Lease{},
+		leased:   IPList{},
+		strategy: FirstFit{},
+	}
+}
+
+// SetStrategy -- use given strategy to choose addresses for new leases.
+// FirstFit is used by default
+func (m *LeaseManager) SetStrategy(s Strategy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.strategy = s
+}
+
+// SetQuarantine -- addresses, released or expired after this call, will be
+// held in the quarantine and excluded from Free() until they age out
+func (m *LeaseManager) SetQuarantine(q *Quarantine) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quarantine = q
+}
+
+// Lease -- lease free address, chosen by the strategy, to the owner for given time.
+// Existing lease of the owner is renewed
+func (m *LeaseManager) Lease(owner string, ttl time.Duration) (Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.clock.Now()
+	m.sweep(now)
+	if l, ok := m.byOwner[owner]; ok {
+		l.Expires = now.Add(ttl)
+		return *l, nil
+	}
+	rng, err := m.strategy.Pick(m.pool, m.free(), owner, 1)
+	if err != nil {
+		return Lease{}, err
+	}
+	l := &Lease{IP: rng.First32(), Owner: owner, Expires: now.Add(ttl)}
+	m.add(l)
+	if m.quarantine != nil {
+		m.quarantine.Allocated()
+	}
+	return *l, nil
+}
+
+// Renew -- prolong active lease of the owner
+func (m *LeaseManager) Renew(owner string, ttl time.Duration) (Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.clock.Now()
+	l, ok := m.byOwner[owner]
+	if !ok || l.Expired(now) {
+		return Lease{}, fmt.Errorf("Active lease of '%s' not found", owner)
+	}
+	l.Expires = now.Add(ttl)
+	return *l, nil
+}
+
+// Release -- release the lease of the owner
+func (m *LeaseManager) Release(owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.byOwner[owner]
+	if !ok {
+		return fmt.Errorf("Lease of '%s' not found", owner)
+	}
+	m.remove(l)
+	return nil
+}
+
+// Sweep -- release expired leases and returns them
+func (m *LeaseManager) Sweep() []Lease {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sweep(m.clock.Now())
+}
+
+// ByOwner -- returns active lease of the owner
+func (m *LeaseManager) ByOwner(owner string) (Lease, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.byOwner[owner]; ok && !l.Expired(m.clock.Now()) {
+		return *l, true
+	}
+	return Lease{}, false
+}
+
+// ByIP -- returns active lease of the address
+func (m *LeaseManager) ByIP(ip uint32) (Lease, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.byIP[ip]; ok && !l.Expired(m.clock.Now()) {
+		return *l, true
+	}
+	return Lease{}, false
+}
+
+// Leases -- returns active leases sorted by address
+func (m *LeaseManager) Leases() []Lease {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.clock.Now()
+	rv := []Lease{}
+	for _, ip := range m.leased {
+		if l := m.byIP[ip]; !l.Expired(now) {
+			rv = append(rv, *l)
+		}
+	}
+	return rv
+}
+
+// Free -- returns arranged list of addresses, which can be leased,
+// i.e. not leased and not in quarantine
+func (m *LeaseManager) Free() IPRangeList {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(m.clock.Now())
+	return m.free()
+}
+
+func (m *LeaseManager) free() IPRangeList {
+	rv := m.pool.Subtract(m.leased.ToRangeList())
+	if m.quarantine != nil {
+		rv = rv.Subtract(m.quarantine.Ranges())
+	}
+	return rv
+}
+
+func (m *LeaseManager) add(l *Lease) {
+	m.byIP[l.IP] = l
+	m.byOwner[l.Owner] = l
+	m.leased.Add(l.IP)
+}
+
+func (m *LeaseManager) remove(l *Lease) {
+	delete(m.byIP, l.IP)
+	delete(m.byOwner, l.Owner)
+	m.leased.Remove(l.IP)
+	if m.quarantine != nil {
+		m.quarantine.Hold(l.IP)
+	}
+}
+
+func (m *LeaseManager) sweep(now time.Time) []Lease {
+	rv := []Lease{}
+	for _, l := range m.byIP {
+		if l.Expired(now) {
+			rv = append(rv, *l)
+		}
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].IP < rv[j].IP })
+	for i := range rv {
+		m.remove(m.byIP[rv[i].IP])
+	}
+	return rv
+}
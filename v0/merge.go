@@ -0,0 +1,415 @@
+package cidr32
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultMergeMemoryLimit -- default memory limit for MergeRanges
+const defaultMergeMemoryLimit = 64 << 20
+
+// rangeRecordSize -- size of IPRange in memory and in temporary files
+const rangeRecordSize = 8
+
+// MergeOptions -- options of MergeRanges
+type MergeOptions struct {
+	// MemoryLimit -- approximate amount of memory in bytes for ranges,
+	// which are sorted in memory. Memory is taken as lines arrive,
+	// the chunk is sorted in place and spilled to temporary file
+	// when the limit is reached. 64MiB if not set
+	MemoryLimit int
+	// TempDir -- directory for temporary files, os.TempDir() if not set
+	TempDir string
+}
+
+// MergeRanges -- read ranges in NewRange format (one per line, empty lines
+// and `#` comments are skipped) and call `emit` for each range of arranged
+// (sorted and glued) result in order.
+// Input of any size is handled by sorting chunks limited by MemoryLimit,
+// spilling them to temporary files and k-way merging of them.
+// Each mergeFanIn spilled files are merged to one, so amount of
+// simultaneously open files stays small
+func MergeRanges(r io.Reader, emit func(*IPRange) error, opts *MergeOptions) error {
+	m := newRangeMerger(opts)
+	defer m.spills.close()
+	if err := m.read(r, ""); err != nil {
+		return err
+	}
+	return m.merge(emit)
+}
+
+// MergeFiles -- like MergeRanges, but read ranges from the files in order.
+// Files are opened one at a time, FeedError of the wrong line
+// contains the file name and the line number in the file
+func MergeFiles(names []string, emit func(*IPRange) error, opts *MergeOptions) error {
+	m := newRangeMerger(opts)
+	defer m.spills.close()
+	for _, name := range names {
+		if err := m.readFile(name); err != nil {
+			return err
+		}
+	}
+	return m.merge(emit)
+}
+
+// MergeRangesTo -- like MergeRanges, but write result to `w`, one range per line
+func MergeRangesTo(r io.Reader, w io.Writer, opts *MergeOptions) error {
+	return mergeTo(w, func(emit func(*IPRange) error) error {
+		return MergeRanges(r, emit, opts)
+	})
+}
+
+// MergeFilesTo -- like MergeFiles, but write result to `w`, one range per line
+func MergeFilesTo(names []string, w io.Writer, opts *MergeOptions) error {
+	return mergeTo(w, func(emit func(*IPRange) error) error {
+		return MergeFiles(names, emit, opts)
+	})
+}
+
+func mergeTo(w io.Writer, merge func(emit func(*IPRange) error) error) error {
+	out := bufio.NewWriter(w)
+	buf := make([]byte, 0, 32)
+	err := merge(func(rng *IPRange) error {
+		buf = append(rng.AppendTo(buf[:0]), '\n')
+		_, err := out.Write(buf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// rangeMerger -- collects ranges of the inputs to the chunk,
+// which is spilled when it reaches chunkLen
+type rangeMerger struct {
+	chunkLen int
+	chunk    IPRangeList
+	spills   *spillLevels
+}
+
+func newRangeMerger(opts *MergeOptions) *rangeMerger {
+	limit, tmpDir := defaultMergeMemoryLimit, ""
+	if opts != nil {
+		if opts.MemoryLimit > 0 {
+			limit = opts.MemoryLimit
+		}
+		tmpDir = opts.TempDir
+	}
+	chunkLen := limit / rangeRecordSize
+	if chunkLen < 1 {
+		chunkLen = 1
+	}
+	return &rangeMerger{
+		chunkLen: chunkLen,
+		chunk:    IPRangeList{},
+		spills:   &spillLevels{tmpDir: tmpDir},
+	}
+}
+
+func (m *rangeMerger) readFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.read(f, name)
+}
+
+// read -- parse lines of the input `name` and add their ranges to the chunk
+func (m *rangeMerger) read(r io.Reader, name string) error {
+	in := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if text := strings.TrimSpace(line); text != "" && !strings.HasPrefix(text, "#") {
+			rng, _, e := parsePlainFeedLine(text)
+			if e != nil {
+				return &FeedError{File: name, Line: n, Text: text, Err: e}
+			}
+			if e := m.add(rng); e != nil {
+				return e
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (m *rangeMerger) add(rng *IPRange) error {
+	if len(m.chunk) == cap(m.chunk) && cap(m.chunk) < m.chunkLen {
+		// grow the chunk up to the limit only as lines arrive
+		newCap := 2*cap(m.chunk) + 64
+		if newCap > m.chunkLen {
+			newCap = m.chunkLen
+		}
+		m.chunk = append(make(IPRangeList, 0, newCap), m.chunk...)
+	}
+	m.chunk = append(m.chunk, *rng)
+	if len(m.chunk) == m.chunkLen {
+		if err := m.spills.add(arrangeInPlace(m.chunk)); err != nil {
+			return err
+		}
+		m.chunk = m.chunk[:0]
+	}
+	return nil
+}
+
+// merge -- merge the chunk with spilled files and emit the result
+func (m *rangeMerger) merge(emit func(*IPRange) error) error {
+	sources, err := m.spills.sources()
+	if err != nil {
+		return err
+	}
+	if err := sources.add(&sliceRangeSource{ranges: arrangeInPlace(m.chunk)}); err != nil {
+		return err
+	}
+	return mergeSources(sources, emit)
+}
+
+// arrangeInPlace -- sort and glue the list without copying
+func arrangeInPlace(r IPRangeList) IPRangeList {
+	sort.Slice(r, func(i, j int) bool { return r[i].First32() < r[j].First32() })
+	rv := r[:0]
+	for _, rng := range r {
+		if n := len(rv) - 1; n >= 0 && uint64(rng.First32()) <= uint64(rv[n].Last32())+1 {
+			if rng.Last32() > rv[n].Last32() {
+				rv[n].i32[1] = rng.Last32()
+			}
+			continue
+		}
+		rv = append(rv, rng)
+	}
+	return rv
+}
+
+// mergeFanIn -- max amount of spilled files, which are merged at once
+const mergeFanIn = 64
+
+// spillLevels -- temporary files with sorted ranges. Files of each level
+// are merged to one file of the next level, when there are mergeFanIn of them
+type spillLevels struct {
+	tmpDir string
+	levels [][]*os.File
+}
+
+// add -- spill sorted chunk to temporary file
+func (s *spillLevels) add(ranges IPRangeList) error {
+	f, err := s.spill(func(emit func(*IPRange) error) error {
+		for i := range ranges {
+			if err := emit(&ranges[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.push(0, f)
+}
+
+func (s *spillLevels) push(level int, f *os.File) error {
+	if level == len(s.levels) {
+		s.levels = append(s.levels, nil)
+	}
+	s.levels[level] = append(s.levels[level], f)
+	if len(s.levels[level]) < mergeFanIn {
+		return nil
+	}
+	files := s.levels[level]
+	s.levels[level] = nil
+	defer closeSpills(files)
+	sources, err := fileSources(files)
+	if err != nil {
+		return err
+	}
+	merged, err := s.spill(func(emit func(*IPRange) error) error {
+		return mergeSources(sources, emit)
+	})
+	if err != nil {
+		return err
+	}
+	return s.push(level+1, merged)
+}
+
+// spill -- write ranges, produced by `fill`, to temporary file
+func (s *spillLevels) spill(fill func(emit func(*IPRange) error) error) (*os.File, error) {
+	f, err := ioutil.TempFile(s.tmpDir, "cidr32-merge-")
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	var rec [rangeRecordSize]byte
+	err = fill(func(rng *IPRange) error {
+		binary.BigEndian.PutUint32(rec[:4], rng.First32())
+		binary.BigEndian.PutUint32(rec[4:], rng.Last32())
+		_, err := w.Write(rec[:])
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		closeSpills([]*os.File{f})
+		return nil, err
+	}
+	return f, nil
+}
+
+// sources -- returns heap of all spilled files
+func (s *spillLevels) sources() (*mergeHeap, error) {
+	var files []*os.File
+	for _, level := range s.levels {
+		files = append(files, level...)
+	}
+	return fileSources(files)
+}
+
+func (s *spillLevels) close() {
+	for _, level := range s.levels {
+		closeSpills(level)
+	}
+}
+
+func fileSources(files []*os.File) (*mergeHeap, error) {
+	sources := &mergeHeap{}
+	for _, f := range files {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := sources.add(&fileRangeSource{rd: bufio.NewReader(f)}); err != nil {
+			return nil, err
+		}
+	}
+	return sources, nil
+}
+
+func closeSpills(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// mergeSources -- k-way merge of sorted sources with gluing
+func mergeSources(sources *mergeHeap, emit func(*IPRange) error) error {
+	var cur *IPRange
+	for sources.Len() > 0 {
+		src := (*sources)[0]
+		next := src.current()
+		if cur != nil && uint64(next.First32()) <= uint64(cur.Last32())+1 {
+			if next.Last32() > cur.Last32() {
+				cur.i32[1] = next.Last32()
+			}
+		} else {
+			if cur != nil {
+				if err := emit(cur); err != nil {
+					return err
+				}
+			}
+			cur = &IPRange{i32: next.i32}
+		}
+		ok, err := src.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(sources, 0)
+		} else {
+			heap.Pop(sources)
+		}
+	}
+	if cur != nil {
+		return emit(cur)
+	}
+	return nil
+}
+
+// rangeSource -- sorted sequence of ranges
+type rangeSource interface {
+	current() IPRange
+	next() (bool, error)
+}
+
+type sliceRangeSource struct {
+	ranges IPRangeList
+	pos    int
+}
+
+func (s *sliceRangeSource) current() IPRange {
+	return s.ranges[s.pos-1]
+}
+
+func (s *sliceRangeSource) next() (bool, error) {
+	if s.pos >= len(s.ranges) {
+		return false, nil
+	}
+	s.pos++
+	return true, nil
+}
+
+type fileRangeSource struct {
+	rd  *bufio.Reader
+	cur IPRange
+}
+
+func (s *fileRangeSource) current() IPRange {
+	return s.cur
+}
+
+func (s *fileRangeSource) next() (bool, error) {
+	var rec [rangeRecordSize]byte
+	if _, err := io.ReadFull(s.rd, rec[:]); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	s.cur.i32 = [2]uint32{binary.BigEndian.Uint32(rec[:4]), binary.BigEndian.Uint32(rec[4:])}
+	return true, nil
+}
+
+// mergeHeap -- https://godoc.org/container/heap#Interface of sources,
+// ordered by their current ranges
+type mergeHeap []rangeSource
+
+func (h mergeHeap) Len() int {
+	return len(h)
+}
+
+func (h mergeHeap) Less(i, j int) bool {
+	return h[i].current().First32() < h[j].current().First32()
+}
+
+func (h mergeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *mergeHeap) Push(x interface{}) {
+	*h = append(*h, x.(rangeSource))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// add -- push source to the heap if it is not empty
+func (h *mergeHeap) add(src rangeSource) error {
+	ok, err := src.next()
+	if ok {
+		heap.Push(h, src)
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package cidr32
+
+import (
+	"fmt"
+)
+
+// Partition -- split the arranged list to `n` shards of near-equal capacity.
+// Capacities of shards differ by one address at most. Result is deterministic
+func (r IPRangeList) Partition(n int) ([]IPRangeList, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("Wrong amount of shards %d", n)
+	}
+	idx := r.Indexed()
+	cuts := make([]int, n+1)
+	for k := 1; k <= n; k++ {
+		cuts[k] = int(uint64(k) * uint64(idx.Len()) / uint64(n))
+	}
+	return idx.shards(cuts)
+}
+
+// PartitionCidr -- split the arranged list to `n` shards of near-equal capacity,
+// but only on boundaries of aligned CIDRs with `prefixLen` or on edges of ranges.
+// So each aligned prefix of such length belongs to one shard.
+// Result is deterministic, some shards can be empty if `n` is large
+func (r IPRangeList) PartitionCidr(n, prefixLen int) ([]IPRangeList, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("Wrong amount of shards %d", n)
+	}
+	if prefixLen < 0 || prefixLen > 32 {
+		return nil, fmt.Errorf("Wrong prefix length %d", prefixLen)
+	}
+	idx := r.Indexed()
+	size := prefixSize(prefixLen)
+	cuts := make([]int, n+1)
+	cuts[n] = idx.Len()
+	for k := 1; k < n; k++ {
+		t := int(uint64(k) * uint64(idx.Len()) / uint64(n))
+		if t >= idx.Len() {
+			cuts[k] = idx.Len()
+			continue
+		}
+		// snap the cut to the nearest block boundary or range edge
+		i := idx.rangeAt(t)
+		rng := idx.ranges[i]
+		ip := uint64(rng.First32()) + uint64(t-idx.offsets[i])
+		lower := ip / size * size
+		if lower < uint64(rng.First32()) {
+			lower = uint64(rng.First32())
+		}
+		upper := ip/size*size + size
+		if upper > uint64(rng.Last32())+1 {
+			upper = uint64(rng.Last32()) + 1
+		}
+		if ip-lower <= upper-ip {
+			cuts[k] = t - int(ip-lower)
+		} else {
+			cuts[k] = t + int(upper-ip)
+		}
+	}
+	return idx.shards(cuts)
+}
+
+// shards -- returns slices between sorted positions
+func (x *IndexedRangeList) shards(cuts []int) ([]IPRangeList, error) {
+	rv := make([]IPRangeList, len(cuts)-1)
+	for i := range rv {
+		shard, err := x.Slice(cuts[i], cuts[i+1])
+		if err != nil {
+			return nil, err
+		}
+		rv[i] = shard
+	}
+	return rv, nil
+}
@@ -0,0 +1,211 @@
+package cidr32
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// poolOwnerPrefix -- prefix of the owner of blocks, delegated to child pools
+const poolOwnerPrefix = "pool:"
+
+// Pool -- node of the pool tree, i.e. region -> zone -> rack -> node.
+// Addresses of the child pool are blocks, carved out of the free space
+// of the parent and allocated there to the owner `pool:<child name>`.
+// Each pool has own Allocator, addresses of the pool, which are not
+// delegated to children, can be allocated by it. Safe for concurrent use
+type Pool struct {
+	mu       sync.Mutex
+	name     string
+	parent   *Pool
+	alloc    *Allocator
+	children map[string]*Pool
+}
+
+// NewPool -- returns root pool of the tree.
+// FirstFit strategy is used if strategy is nil
+func NewPool(name string, ranges IPRangeList, strategy Strategy) *Pool {
+	return &Pool{
+		name:     name,
+		alloc:    newPoolAllocator(ranges, strategy),
+		children: map[string]*Pool{},
+	}
+}
+
+func newPoolAllocator(ranges IPRangeList, strategy Strategy) *Allocator {
+	a := NewAllocator(ranges, strategy)
+	a.managed = poolOwnerPrefix
+	return a
+}
+
+// Name -- returns name of the pool
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// Path -- returns names of the pool and its parents, joined by `/`
+func (p *Pool) Path() string {
+	if p.parent == nil {
+		return p.name
+	}
+	return p.parent.Path() + "/" + p.name
+}
+
+// Parent -- returns parent pool or nil for the root
+func (p *Pool) Parent() *Pool {
+	return p.parent
+}
+
+// Allocator -- returns allocator of the pool.
+// Blocks of child pools can't be allocated or released through it
+func (p *Pool) Allocator() *Allocator {
+	return p.alloc
+}
+
+// Ranges -- returns arranged addresses of the pool
+func (p *Pool) Ranges() IPRangeList {
+	return p.alloc.Pool()
+}
+
+// Child -- returns child pool with given name or nil
+func (p *Pool) Child(name string) *Pool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.children[name]
+}
+
+// Children -- returns child pools sorted by name
+func (p *Pool) Children() []*Pool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rv := make([]*Pool, 0, len(p.children))
+	for _, child := range p.children {
+		rv = append(rv, child)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].name < rv[j].name })
+	return rv
+}
+
+// NewChild -- create child pool with block of `size` addresses,
+// allocated from the pool by its strategy.
+// FirstFit strategy is used by the child if strategy is nil
+func (p *Pool) NewChild(name string, size int, strategy Strategy) (*Pool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("Wrong pool name '%s'", name)
+	}
+	if _, ok := p.children[name]; ok {
+		return nil, fmt.Errorf("Pool '%s' already has child '%s'", p.Path(), name)
+	}
+	alloc, err := p.alloc.allocateBlock(poolOwnerPrefix+name, size)
+	if err != nil {
+		return nil, err
+	}
+	child := &Pool{
+		name:     name,
+		parent:   p,
+		alloc:    newPoolAllocator(IPRangeList{alloc.Range}, strategy),
+		children: map[string]*Pool{},
+	}
+	p.children[name] = child
+	return child, nil
+}
+
+// RemoveChild -- remove empty child pool and return its addresses
+// to the pool. Returns error if any address of the child is allocated,
+// reserved or in quarantine
+func (p *Pool) RemoveChild(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	child, ok := p.children[name]
+	if !ok {
+		return fmt.Errorf("Pool '%s' has no child '%s'", p.Path(), name)
+	}
+	blocks := child.blocks()
+	for i := range blocks {
+		if err := child.alloc.RemovePool(&blocks[i]); err != nil {
+			// return already removed blocks back to the child
+			for j := 0; j < i; j++ {
+				child.alloc.AddPool(&blocks[j])
+			}
+			return err
+		}
+	}
+	p.alloc.releaseOwner(poolOwnerPrefix + name)
+	delete(p.children, name)
+	return nil
+}
+
+// Grow -- extend the pool by block of `size` addresses,
+// allocated from the parent. Root pool can't grow
+func (p *Pool) Grow(size int) (IPRange, error) {
+	if p.parent == nil {
+		return IPRange{}, fmt.Errorf("Can't grow root pool '%s'", p.name)
+	}
+	p.parent.mu.Lock()
+	defer p.parent.mu.Unlock()
+	if p.parent.children[p.name] != p {
+		return IPRange{}, fmt.Errorf("Can't grow removed pool '%s'", p.Path())
+	}
+	alloc, err := p.parent.alloc.allocateBlock(poolOwnerPrefix+p.name, size)
+	if err != nil {
+		return IPRange{}, err
+	}
+	p.alloc.AddPool(&alloc.Range)
+	return alloc.Range, nil
+}
+
+// Shrink -- return empty blocks of the pool to the parent.
+// Block is empty if none of its addresses is allocated (including
+// allocations of child pools), reserved or in quarantine.
+// Returns list of returned blocks
+func (p *Pool) Shrink() (IPRangeList, error) {
+	rv := IPRangeList{}
+	if p.parent == nil {
+		return rv, nil
+	}
+	p.parent.mu.Lock()
+	defer p.parent.mu.Unlock()
+	for _, block := range p.blocks() {
+		if err := p.alloc.RemovePool(&block); err != nil {
+			continue
+		}
+		if err := p.parent.alloc.release(block.First32(), false); err != nil {
+			p.alloc.AddPool(&block)
+			return rv, err
+		}
+		rv = append(rv, block)
+	}
+	return rv, nil
+}
+
+// RemoveRange -- exclude the range from the root pool. The root pool
+// refuses to shrink below the space, allocated by it or held by children
+func (p *Pool) RemoveRange(r *IPRange) error {
+	if p.parent != nil {
+		return fmt.Errorf("Can't remove range from child pool '%s', use Shrink", p.Path())
+	}
+	return p.alloc.RemovePool(r)
+}
+
+// AddRange -- extend the root pool by the range
+func (p *Pool) AddRange(r *IPRange) error {
+	if p.parent != nil {
+		return fmt.Errorf("Can't add range to child pool '%s', use Grow", p.Path())
+	}
+	p.alloc.AddPool(r)
+	return nil
+}
+
+// blocks -- returns blocks, allocated to the pool by the parent
+func (p *Pool) blocks() IPRangeList {
+	rv := IPRangeList{}
+	for _, alloc := range p.parent.alloc.Allocations() {
+		if alloc.Owner == poolOwnerPrefix+p.name {
+			rv = append(rv, alloc.Range)
+		}
+	}
+	return rv
+}
@@ -0,0 +1,190 @@
+package cidr32
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+// Action -- decision of the prefix list entry or ACL rule
+type Action int
+
+const (
+	Permit Action = iota
+	Deny
+)
+
+func (a Action) String() string {
+	if a == Deny {
+		return "deny"
+	}
+	return "permit"
+}
+
+// prefixListSeqStep -- default step of sequence numbers, like Cisco IOS does
+const prefixListSeqStep = 5
+
+// PrefixListEntry -- one entry of the prefix list.
+// Ge and Le are length bounds of matched prefixes, 0 means not set
+type PrefixListEntry struct {
+	Seq    int
+	Action Action
+	Prefix *net.IPNet
+	Ge     int
+	Le     int
+}
+
+// PrefixList -- ordered list of prefix list entries
+type PrefixList struct {
+	Name    string
+	Entries []PrefixListEntry
+}
+
+// NewPrefixList -- returns empty prefix list
+func NewPrefixList(name string) *PrefixList {
+	return &PrefixList{
+		Name:    name,
+		Entries: []PrefixListEntry{},
+	}
+}
+
+// AddRanges -- aggregate the range list to minimal set of CIDRs
+// and append an entry with given action and length bounds for each of them
+func (p *PrefixList) AddRanges(action Action, rl IPRangeList, ge, le int) error {
+	cidrs := rl.Cidrs()
+	for _, cidr := range cidrs {
+		if err := checkPrefixBounds(cidr, ge, le); err != nil {
+			return err
+		}
+	}
+	for _, cidr := range cidrs {
+		p.Entries = append(p.Entries, PrefixListEntry{
+			Seq:    p.nextSeq(),
+			Action: action,
+			Prefix: cidr,
+			Ge:     ge,
+			Le:     le,
+		})
+	}
+	return nil
+}
+
+// AddPrefixes -- aggregate the prefix set to minimal set of CIDRs
+// and append an entry with given action and length bounds for each of them
+func (p *PrefixList) AddPrefixes(action Action, prefixes []*net.IPNet, ge, le int) error {
+	rl := IPRangeList{}
+	for _, prefix := range prefixes {
+		rng, err := CidrToRange(prefix, false)
+		if err != nil {
+			return err
+		}
+		rl = append(rl, *rng)
+	}
+	return p.AddRanges(action, rl, ge, le)
+}
+
+func (p *PrefixList) nextSeq() int {
+	if n := len(p.Entries); n > 0 {
+		return p.Entries[n-1].Seq + prefixListSeqStep
+	}
+	return prefixListSeqStep
+}
+
+func checkPrefixBounds(cidr *net.IPNet, ge, le int) error {
+	n, _ := cidr.Mask.Size()
+	if ge != 0 && (ge <= n || ge > 32) {
+		return fmt.Errorf("Wrong 'ge %d' for prefix %s, should be in (%d..32]", ge, cidr, n)
+	}
+	if le != 0 && (le <= n || le > 32 || le < ge) {
+		return fmt.Errorf("Wrong 'le %d' for prefix %s, should be in (%d..32] and not less than 'ge'", le, cidr, n)
+	}
+	return nil
+}
+
+// WriteCisco -- write prefix list in the Cisco IOS syntax
+func (p *PrefixList) WriteCisco(w io.Writer) error {
+	lines := make([]string, len(p.Entries))
+	for i, e := range p.Entries {
+		lines[i] = fmt.Sprintf("ip prefix-list %s seq %d %s %s", p.Name, e.Seq, e.Action, e.Prefix)
+		if e.Ge != 0 {
+			lines[i] = lines[i] + fmt.Sprintf(" ge %d", e.Ge)
+		}
+		if e.Le != 0 {
+			lines[i] = lines[i] + fmt.Sprintf(" le %d", e.Le)
+		}
+	}
+	return writeLines(w, lines)
+}
+
+// WriteFRR -- write prefix list in the FRR syntax, which is the same as Cisco IOS one
+func (p *PrefixList) WriteFRR(w io.Writer) error {
+	return p.WriteCisco(w)
+}
+
+// WriteJuniper -- write prefix list as Juniper policy-options policy statement.
+// Each entry is a term with route-filter, unmatched routes are rejected
+// like the implicit deny of prefix lists
+func (p *PrefixList) WriteJuniper(w io.Writer) error {
+	lines := []string{
+		"policy-options {",
+		fmt.Sprintf("    policy-statement %s {", p.Name),
+	}
+	for _, e := range p.Entries {
+		var match string
+		switch {
+		case e.Ge != 0 && e.Le != 0:
+			match = fmt.Sprintf("prefix-length-range /%d-/%d", e.Ge, e.Le)
+		case e.Ge != 0:
+			match = fmt.Sprintf("prefix-length-range /%d-/32", e.Ge)
+		case e.Le != 0:
+			match = fmt.Sprintf("upto /%d", e.Le)
+		default:
+			match = "exact"
+		}
+		then := "accept"
+		if e.Action == Deny {
+			then = "reject"
+		}
+		lines = append(lines,
+			fmt.Sprintf("        term seq-%d {", e.Seq),
+			fmt.Sprintf("            from route-filter %s %s;", e.Prefix, match),
+			fmt.Sprintf("            then %s;", then),
+			"        }",
+		)
+	}
+	lines = append(lines,
+		"        term default {",
+		"            then reject;",
+		"        }",
+		"    }",
+		"}",
+	)
+	return writeLines(w, lines)
+}
+
+// WriteBird -- write prefix list as BIRD filter.
+// Unmatched routes are rejected like the implicit deny of prefix lists
+func (p *PrefixList) WriteBird(w io.Writer) error {
+	lines := []string{fmt.Sprintf("filter %s {", p.Name)}
+	for _, e := range p.Entries {
+		n, _ := e.Prefix.Mask.Size()
+		pattern := e.Prefix.String()
+		if e.Ge != 0 || e.Le != 0 {
+			ge, le := n, 32
+			if e.Ge != 0 {
+				ge = e.Ge
+			}
+			if e.Le != 0 {
+				le = e.Le
+			}
+			pattern = pattern + fmt.Sprintf("{%d,%d}", ge, le)
+		}
+		then := "accept"
+		if e.Action == Deny {
+			then = "reject"
+		}
+		lines = append(lines, fmt.Sprintf("\tif net ~ [ %s ] then %s; # seq %d", pattern, then, e.Seq))
+	}
+	lines = append(lines, "\treject;", "}")
+	return writeLines(w, lines)
+}
@@ -0,0 +1,118 @@
+package cidr32
+
+import (
+	"sync"
+	"time"
+)
+
+// QuarantineStats -- statistics of the quarantine
+type QuarantineStats struct {
+	// Held -- amount of addresses in quarantine now
+	Held int
+	// Total -- amount of addresses, which were put to quarantine
+	Total int
+	// Released -- amount of addresses, which aged out of quarantine
+	Released int
+}
+
+type quarantineEntry struct {
+	since       time.Time
+	allocations int
+}
+
+// Quarantine -- cool-down list of released addresses, which should not be
+// reused until given duration passes or given amount of allocations is made.
+// Zero duration or allocations limit means the criteria is not used.
+// If both are set, address ages out when any of them is reached.
+// Safe for concurrent use
+type Quarantine struct {
+	mu          sync.Mutex
+	duration    time.Duration
+	allocations int
+	clock       Clock
+	entries     map[uint32]*quarantineEntry
+	ips         IPList
+	stats       QuarantineStats
+}
+
+// NewQuarantine -- returns empty quarantine.
+// SystemClock is used if clock is nil
+func NewQuarantine(duration time.Duration, allocations int, clock Clock) *Quarantine {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &Quarantine{
+		duration:    duration,
+		allocations: allocations,
+		clock:       clock,
+		entries:     map[uint32]*quarantineEntry{},
+		ips:         IPList{},
+	}
+}
+
+// Hold -- put released addresses to quarantine
+func (q *Quarantine) Hold(ips ...uint32) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock.Now()
+	for _, ip := range ips {
+		if _, ok := q.entries[ip]; !ok {
+			q.stats.Total++
+		}
+		q.entries[ip] = &quarantineEntry{since: now}
+		q.ips.Add(ip)
+	}
+}
+
+// Allocated -- count allocation, made from the pool
+func (q *Quarantine) Allocated() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, e := range q.entries {
+		e.allocations++
+	}
+}
+
+// Has -- returns true if the address is in quarantine
+func (q *Quarantine) Has(ip uint32) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sweep()
+	return q.ips.Has(ip)
+}
+
+// IPs -- returns copy of sorted list of addresses in quarantine
+func (q *Quarantine) IPs() *IPList {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sweep()
+	rv := append(IPList{}, q.ips...)
+	return &rv
+}
+
+// Ranges -- returns arranged list of addresses in quarantine
+func (q *Quarantine) Ranges() IPRangeList {
+	return q.IPs().ToRangeList()
+}
+
+// Stats -- returns statistics of the quarantine
+func (q *Quarantine) Stats() QuarantineStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sweep()
+	rv := q.stats
+	rv.Held = len(q.ips)
+	return rv
+}
+
+// sweep -- release aged out addresses
+func (q *Quarantine) sweep() {
+	now := q.clock.Now()
+	for ip, e := range q.entries {
+		if (q.duration > 0 && now.Sub(e.since) >= q.duration) || (q.allocations > 0 && e.allocations >= q.allocations) {
+			delete(q.entries, ip)
+			q.ips.Remove(ip)
+			q.stats.Released++
+		}
+	}
+}
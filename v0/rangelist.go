@@ -1,6 +1,9 @@
 package cidr32
 
 import (
+	"fmt"
+	"net"
+	"sort"
 	"strings"
 )
 
@@ -76,21 +79,107 @@ func (r IPRangeList) Capacity() (rv int) {
 	return rv
 }
 
-// Sorted --
+// Contains -- returns true if the address is inside one of ranges.
+// Uses binary search, so the list should be arranged (see Arranged())
+func (r IPRangeList) Contains(ip uint32) bool {
+	i := sort.Search(len(r), func(i int) bool { return r[i].Last32() >= ip })
+	return i < len(r) && r[i].Contains(ip)
+}
+
+// Subtract -- returns arranged list of addresses from the list,
+// which are absent in the `other` list
+func (r IPRangeList) Subtract(other IPRangeList) IPRangeList {
+	rv := IPRangeList{}
+	ex := other.Arranged()
+	j := 0
+	for _, rng := range r.Arranged() {
+		first, last := uint64(rng.First32()), uint64(rng.Last32())
+		for j < len(ex) && uint64(ex[j].Last32()) < first {
+			j++
+		}
+		for k := j; k < len(ex) && uint64(ex[k].First32()) <= last && first <= last; k++ {
+			if uint64(ex[k].First32()) > first {
+				rv = append(rv, IPRange{i32: [2]uint32{uint32(first), ex[k].First32() - 1}})
+			}
+			first = uint64(ex[k].Last32()) + 1
+		}
+		if first <= last {
+			rv = append(rv, IPRange{i32: [2]uint32{uint32(first), uint32(last)}})
+		}
+	}
+	return rv
+}
+
+// Complement -- returns arranged list of free holes inside the universe,
+// i.e. addresses of universe, which are absent in the list
+func (r IPRangeList) Complement(universe *IPRange) IPRangeList {
+	return IPRangeList{*universe}.Subtract(r)
+}
+
+// Cidrs -- returns minimal list of aligned CIDRs, which covers the list
+func (r IPRangeList) Cidrs() []*net.IPNet {
+	var rv []*net.IPNet
+	for _, rng := range r.Arranged() {
+		rv = append(rv, rng.Cidrs()...)
+	}
+	return rv
+}
+
+// Expand -- returns sorted list of unique addresses from all ranges
+// or error if more than `limit` addresses would be produced
+func (r IPRangeList) Expand(limit int) (*IPList, error) {
+	ranges := r.Arranged()
+	if n := ranges.Capacity(); n > limit {
+		return nil, fmt.Errorf("Can't expand range list: %d addresses exceeds limit %d", n, limit)
+	}
+	rv := make(IPList, 0, ranges.Capacity())
+	for _, rng := range ranges {
+		for ip := rng.First32(); ; ip++ {
+			rv = append(rv, ip)
+			if ip == rng.Last32() {
+				break
+			}
+		}
+	}
+	return &rv, nil
+}
+
+// Sorted -- returns a sorted copy of the list.
+// Ranges are ordered by the first address, then by the last one
 func (r IPRangeList) Sorted() IPRangeList {
-	// todo(sv): IMPLEMENT!!!
-	return r
+	rv := append(IPRangeList{}, r...)
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].First32() == rv[j].First32() {
+			return rv[i].Last32() < rv[j].Last32()
+		}
+		return rv[i].First32() < rv[j].First32()
+	})
+	return rv
 }
 
-// Glued --
+// Glued -- returns a copy of the list, where each overlapped or adjacent
+// neighbours are joined to one range. The list should be sorted to get
+// fully glued result
 func (r IPRangeList) Glued() IPRangeList {
-	// todo(sv): IMPLEMENT!!!
-	return r
+	rv := IPRangeList{}
+	for _, rng := range r {
+		n := len(rv) - 1
+		if n >= 0 && uint64(rng.First32()) <= uint64(rv[n].Last32())+1 && uint64(rng.Last32())+1 >= uint64(rv[n].First32()) {
+			if rng.First32() < rv[n].First32() {
+				rv[n].i32[0] = rng.First32()
+			}
+			if rng.Last32() > rv[n].Last32() {
+				rv[n].i32[1] = rng.Last32()
+			}
+			continue
+		}
+		rv = append(rv, rng)
+	}
+	return rv
 }
 
 // Arranged -- sorted and Glued
 func (r IPRangeList) Arranged() IPRangeList {
-	// todo(sv): IMPLEMENT!!!
 	tmp := r.Sorted()
 	return tmp.Glued()
 }
@@ -0,0 +1,128 @@
+package cidr32
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// IPRangeMapEntry -- range with associated value
+type IPRangeMapEntry struct {
+	Range IPRange
+	Value interface{}
+}
+
+// IPRangeMap -- sorted list of non-overlapped ranges with associated values.
+// Lookups are binary searches
+type IPRangeMap struct {
+	entries []IPRangeMapEntry
+}
+
+// NewIPRangeMap -- returns empty map
+func NewIPRangeMap() *IPRangeMap {
+	return &IPRangeMap{
+		entries: []IPRangeMapEntry{},
+	}
+}
+
+// Len -- returns amount of entries
+func (m *IPRangeMap) Len() int {
+	return len(m.entries)
+}
+
+// Entries -- returns copy of the sorted entries
+func (m *IPRangeMap) Entries() []IPRangeMapEntry {
+	return append([]IPRangeMapEntry{}, m.entries...)
+}
+
+// Ranges -- returns sorted list of ranges of all entries
+func (m *IPRangeMap) Ranges() IPRangeList {
+	rv := make(IPRangeList, len(m.entries))
+	for i, e := range m.entries {
+		rv[i] = e.Range
+	}
+	return rv
+}
+
+// search -- returns index of the first entry, which ends not before `ip`
+func (m *IPRangeMap) search(ip uint32) int {
+	return sort.Search(len(m.entries), func(i int) bool { return m.entries[i].Range.Last32() >= ip })
+}
+
+// Set -- associate the value with the range.
+// Values of overlapped parts of existing entries are overridden
+func (m *IPRangeMap) Set(r *IPRange, value interface{}) {
+	m.replace(r, []IPRangeMapEntry{{Range: *r, Value: value}})
+}
+
+// Delete -- remove the range from the map.
+// Overlapped parts of existing entries are removed
+func (m *IPRangeMap) Delete(r *IPRange) {
+	m.replace(r, nil)
+}
+
+// replace -- replace overlapped parts of existing entries by given entries
+func (m *IPRangeMap) replace(r *IPRange, entries []IPRangeMapEntry) {
+	i := m.search(r.First32())
+	j := i
+	var left, right []IPRangeMapEntry
+	for ; j < len(m.entries) && m.entries[j].Range.First32() <= r.Last32(); j++ {
+		e := m.entries[j]
+		if e.Range.First32() < r.First32() {
+			left = append(left, IPRangeMapEntry{
+				Range: IPRange{i32: [2]uint32{e.Range.First32(), r.First32() - 1}},
+				Value: e.Value,
+			})
+		}
+		if e.Range.Last32() > r.Last32() {
+			right = append(right, IPRangeMapEntry{
+				Range: IPRange{i32: [2]uint32{r.Last32() + 1, e.Range.Last32()}},
+				Value: e.Value,
+			})
+		}
+	}
+	tmp := append([]IPRangeMapEntry{}, m.entries[:i]...)
+	tmp = append(tmp, left...)
+	tmp = append(tmp, entries...)
+	tmp = append(tmp, right...)
+	m.entries = append(tmp, m.entries[j:]...)
+}
+
+// Lookup -- returns entry, which contains the address
+func (m *IPRangeMap) Lookup(ip uint32) (IPRangeMapEntry, bool) {
+	if i := m.search(ip); i < len(m.entries) && m.entries[i].Range.Contains(ip) {
+		return m.entries[i], true
+	}
+	return IPRangeMapEntry{}, false
+}
+
+// Overlapping -- returns entries, which are intersected with the range
+func (m *IPRangeMap) Overlapping(r *IPRange) []IPRangeMapEntry {
+	rv := []IPRangeMapEntry{}
+	for i := m.search(r.First32()); i < len(m.entries) && m.entries[i].Range.First32() <= r.Last32(); i++ {
+		rv = append(rv, m.entries[i])
+	}
+	return rv
+}
+
+// glue -- join adjacent entries with equal values.
+// Values should be comparable
+func (m *IPRangeMap) glue() {
+	rv := m.entries[:0]
+	for _, e := range m.entries {
+		if n := len(rv) - 1; n >= 0 && rv[n].Value == e.Value && uint64(rv[n].Range.Last32())+1 == uint64(e.Range.First32()) {
+			rv[n].Range.i32[1] = e.Range.Last32()
+			continue
+		}
+		rv = append(rv, e)
+	}
+	m.entries = rv
+}
+
+func (m *IPRangeMap) String() string {
+	rv := make([]string, len(m.entries))
+	for i, e := range m.entries {
+		rv[i] = e.Range.String() + " " + fmt.Sprint(e.Value)
+	}
+	return strings.Join(rv, "\n")
+}
@@ -19,23 +19,140 @@ func (r IPRange) Last32() uint32 {
 	return r.i32[1]
 }
 
+// First -- returns first address of the range. Allocates new net.IP,
+// use First4, First32 or AppendTo on hot paths
 func (r *IPRange) First() net.IP {
 	return Uint32toIP(r.First32())
 }
 
+// Last -- returns last address of the range. Allocates new net.IP,
+// use Last4, Last32 or AppendTo on hot paths
 func (r *IPRange) Last() net.IP {
 	return Uint32toIP(r.Last32())
 }
 
+// First4 -- returns first address of the range as array of 4 bytes. Does not allocate.
+func (r *IPRange) First4() [4]byte {
+	return Uint32to4(r.First32())
+}
+
+// Last4 -- returns last address of the range as array of 4 bytes. Does not allocate.
+func (r *IPRange) Last4() [4]byte {
+	return Uint32to4(r.Last32())
+}
+
 func (r *IPRange) Len() int {
 	return int(r.i32[1]-r.i32[0]) + 1
 }
 
+// AppendTo -- append `A.B.C.D-E.F.G.H` form of the range to `dst`
+// and return the extended buffer. Does not allocate if `dst` has enough capacity.
+func (r *IPRange) AppendTo(dst []byte) []byte {
+	dst = AppendUint32IP(dst, r.i32[0])
+	dst = append(dst, '-')
+	return AppendUint32IP(dst, r.i32[1])
+}
+
+// String -- returns `A.B.C.D-E.F.G.H` form of the range.
+// Allocates the resulting string only, use AppendTo to avoid it
 func (r *IPRange) String() string {
-	return fmt.Sprintf("%s-%s", Uint32toIP(r.i32[0]), Uint32toIP(r.i32[1]))
+	var buf [31]byte // max length of `A.B.C.D-E.F.G.H`
+	return string(r.AppendTo(buf[:0]))
+}
+
+// MarshalText -- implements https://godoc.org/encoding#TextMarshaler.
+// Used by JSON encoding
+func (r IPRange) MarshalText() ([]byte, error) {
+	return r.AppendTo(make([]byte, 0, 31)), nil
+}
+
+// UnmarshalText -- implements https://godoc.org/encoding#TextUnmarshaler.
+// Accepts formats of NewRange
+func (r *IPRange) UnmarshalText(text []byte) error {
+	rng, err := NewRange(string(text))
+	if err != nil {
+		return err
+	}
+	*r = *rng
+	return nil
+}
+
+// Cidr -- returns CIDR, which is exactly equal to the range,
+// or nil if the range is not an aligned prefix
+func (r *IPRange) Cidr() *net.IPNet {
+	if n := alignedPrefixLen(uint64(r.First32()), uint64(r.Last32())); uint64(r.First32())+prefixSize(n)-1 == uint64(r.Last32()) {
+		return newCidr(r.First32(), n)
+	}
+	return nil
+}
+
+// Cidrs -- returns minimal list of aligned CIDRs, which covers the range
+func (r *IPRange) Cidrs() []*net.IPNet {
+	var rv []*net.IPNet
+	for first, last := uint64(r.First32()), uint64(r.Last32()); first <= last; {
+		n := alignedPrefixLen(first, last)
+		rv = append(rv, newCidr(uint32(first), n))
+		first = first + prefixSize(n)
+	}
+	return rv
+}
+
+// LargestCidr -- returns the largest aligned CIDR, which fits in the range.
+// returns the leftmost one if there are several such CIDRs
+func (r *IPRange) LargestCidr() (rv *net.IPNet) {
+	best := 33
+	for _, cidr := range r.Cidrs() {
+		if n, _ := cidr.Mask.Size(); n < best {
+			best, rv = n, cidr
+		}
+	}
+	return rv
 }
 
-//Cidr()
+// FirstCidr -- returns the first aligned CIDR with given prefix length,
+// which fits in the range, or nil if there is no such one
+func (r *IPRange) FirstCidr(prefixLen int) *net.IPNet {
+	if prefixLen < 0 || prefixLen > 32 {
+		return nil
+	}
+	size := prefixSize(prefixLen)
+	first := (uint64(r.First32()) + size - 1) / size * size
+	if first+size-1 > uint64(r.Last32()) {
+		return nil
+	}
+	return newCidr(uint32(first), prefixLen)
+}
+
+// alignedPrefixLen -- returns length of the largest prefix,
+// which starts from `first` and ends not after `last`
+func alignedPrefixLen(first, last uint64) int {
+	n := 32
+	for n > 0 {
+		size := prefixSize(n - 1)
+		if first%size != 0 || first+size-1 > last {
+			break
+		}
+		n = n - 1
+	}
+	return n
+}
+
+// prefixSize -- returns amount of addresses in the prefix with given length
+func prefixSize(prefixLen int) uint64 {
+	return uint64(1) << uint(32-prefixLen)
+}
+
+func newCidr(first uint32, prefixLen int) *net.IPNet {
+	return &net.IPNet{
+		IP:   Uint32toIP(first),
+		Mask: net.CIDRMask(prefixLen, 32),
+	}
+}
+
+// Contains -- return true if the address is inside the range
+func (r *IPRange) Contains(ip uint32) bool {
+	return ip >= r.First32() && ip <= r.Last32()
+}
 
 // IsIntersect -- return true if base range intercects with given
 func (r *IPRange) IsIntersect(exRange *IPRange) (rv bool) {
@@ -125,18 +242,32 @@ func NewIPRange(first, last net.IP) (*IPRange, error) {
 	return New32Range(a, b)
 }
 
-// NewRange -- got IP range in the `A.B.C.D-E.F.G.H` or `A.B.C.D` for single
-// address format. return pointer to IPRange struct
+// NewRange -- got IP range in the `A.B.C.D-E.F.G.H`, `A.B.C.D/N` (whole CIDR)
+// or `A.B.C.D` for single address format. return pointer to IPRange struct.
+// Spaces around the string and around addresses are ignored.
+// Host bits of CIDR are ignored, i.e. `10.0.0.5/24` is `10.0.0.0-10.0.0.255`.
+// IPv6 addresses (except IPv4-mapped ones, i.e. `::ffff:A.B.C.D/N`
+// with N >= 96) and ranges with more than two addresses are rejected
 func NewRange(rangeS string) (*IPRange, error) {
 	var ips [2]net.IP
+	if strings.Contains(rangeS, "/") {
+		_, cidr, err := net.ParseCIDR(strings.TrimSpace(rangeS))
+		if err == nil {
+			cidr, err = cidr4(cidr)
+		}
+		if err != nil {
+			return &IPRange{}, fmt.Errorf("Can't parse range '%s': wrong CIDR", rangeS)
+		}
+		return CidrToRange(cidr, false)
+	}
 	addrs := strings.Split(strings.TrimSpace(rangeS), "-")
-	if len(addrs) == 0 {
+	if len(addrs) == 0 || len(addrs) > 2 {
 		return &IPRange{}, fmt.Errorf("Can't parse range '%s', wrong format", rangeS)
 	} else if len(addrs) == 1 {
 		addrs = append(addrs, addrs[0])
 	}
 	for i, aS := range addrs {
-		if ip := net.ParseIP(aS); ip != nil {
+		if ip := net.ParseIP(strings.TrimSpace(aS)); ip != nil && ip.To4() != nil {
 			ips[i] = ip.To4()
 		} else {
 			return &IPRange{}, fmt.Errorf("Can't parse range '%s': addr '%s' wrong", rangeS, aS)
@@ -146,8 +277,13 @@ func NewRange(rangeS string) (*IPRange, error) {
 }
 
 // CidrToRange -- returns a pointer to IPRange for whole CIDR
-// or without NET and Broadcast addresses if rserveation enabled
+// or without NET and Broadcast addresses if rserveation enabled.
+// IPv4-mapped IPv6 CIDR is converted to IPv4 one, other IPv6 CIDRs
+// are rejected
 func CidrToRange(cidr *net.IPNet, reserveNetBorders bool) (rv *IPRange, err error) {
+	if cidr, err = cidr4(cidr); err != nil {
+		return nil, err
+	}
 	first := IPtoUint32(cidr.IP.To4())
 	last := first | ^IPtoUint32(net.IP(cidr.Mask).To4())
 	if n, _ := cidr.Mask.Size(); reserveNetBorders && n < 31 {
@@ -157,3 +293,18 @@ func CidrToRange(cidr *net.IPNet, reserveNetBorders bool) (rv *IPRange, err erro
 	}
 	return rv, err
 }
+
+// cidr4 -- returns CIDR with 4-byte address and mask. IPv4-mapped IPv6
+// CIDR `::ffff:A.B.C.D/N` is converted to `A.B.C.D/(N-96)`, it should have
+// N >= 96. Other IPv6 CIDRs and non-canonical masks are rejected
+func cidr4(cidr *net.IPNet) (*net.IPNet, error) {
+	ones, bits := cidr.Mask.Size()
+	ip := cidr.IP.To4()
+	switch {
+	case ip != nil && bits == 32:
+		return &net.IPNet{IP: ip, Mask: cidr.Mask}, nil
+	case ip != nil && bits == 128 && ones >= 96:
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(ones-96, 32)}, nil
+	}
+	return nil, fmt.Errorf("CIDR '%s' is not IPv4 one", cidr)
+}
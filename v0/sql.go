@@ -0,0 +1,207 @@
+package cidr32
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Value -- implements https://godoc.org/database/sql/driver#Valuer.
+// Range, which is a prefix, is stored as PostgreSQL `cidr`/`inet`,
+// other ranges as `int8range` of uint32 addresses
+func (r IPRange) Value() (driver.Value, error) {
+	if cidr := r.Cidr(); cidr != nil {
+		return cidr.String(), nil
+	}
+	return fmt.Sprintf("[%d,%d]", r.First32(), r.Last32()), nil
+}
+
+// Scan -- implements https://godoc.org/database/sql#Scanner.
+// Accepts PostgreSQL `cidr`, `inet`, `int8range` and NewRange formats.
+// `inet` with host bits (i.e. `10.0.0.5/24`) is a single address
+func (r *IPRange) Scan(src interface{}) error {
+	s, err := sqlString(src)
+	if err != nil {
+		return err
+	}
+	rng, err := parseSQLRange(s)
+	if err != nil {
+		return err
+	}
+	*r = *rng
+	return nil
+}
+
+// Value -- implements https://godoc.org/database/sql/driver#Valuer.
+// List is stored as PostgreSQL `cidr[]` of aggregated prefixes
+func (r IPRangeList) Value() (driver.Value, error) {
+	cidrs := r.Cidrs()
+	rv := make([]string, len(cidrs))
+	for i, cidr := range cidrs {
+		rv[i] = cidr.String()
+	}
+	return "{" + strings.Join(rv, ",") + "}", nil
+}
+
+// Scan -- implements https://godoc.org/database/sql#Scanner.
+// Accepts PostgreSQL arrays of values, which are accepted by IPRange.Scan
+func (r *IPRangeList) Scan(src interface{}) error {
+	if src == nil {
+		*r = nil
+		return nil
+	}
+	elements, err := sqlArray(src)
+	if err != nil {
+		return err
+	}
+	rv := make(IPRangeList, len(elements))
+	for i, e := range elements {
+		rng, err := parseSQLRange(e)
+		if err != nil {
+			return err
+		}
+		rv[i] = *rng
+	}
+	*r = rv
+	return nil
+}
+
+// Value -- implements https://godoc.org/database/sql/driver#Valuer.
+// List is stored as PostgreSQL `inet[]`
+func (r IPList) Value() (driver.Value, error) {
+	return "{" + strings.Join(r.normalized().Strings(), ",") + "}", nil
+}
+
+// Scan -- implements https://godoc.org/database/sql#Scanner.
+// Accepts PostgreSQL `inet[]` arrays, netmasks of addresses are ignored
+func (r *IPList) Scan(src interface{}) error {
+	if src == nil {
+		*r = nil
+		return nil
+	}
+	elements, err := sqlArray(src)
+	if err != nil {
+		return err
+	}
+	rv := make(IPList, len(elements))
+	for i, e := range elements {
+		if n := strings.Index(e, "/"); n >= 0 {
+			e = e[:n]
+		}
+		ip := net.ParseIP(e)
+		if ip == nil || ip.To4() == nil {
+			return fmt.Errorf("Can't scan IP list: wrong address '%s'", e)
+		}
+		rv[i] = IPtoUint32(ip)
+	}
+	rv.Unique()
+	*r = rv
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+func sqlString(src interface{}) (string, error) {
+	switch v := src.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case nil:
+		return "", fmt.Errorf("Can't scan NULL to IPRange")
+	}
+	return "", fmt.Errorf("Can't scan %T to IPRange", src)
+}
+
+// sqlArray -- returns elements of one-dimensional PostgreSQL array literal
+func sqlArray(src interface{}) ([]string, error) {
+	s, err := sqlString(src)
+	if err != nil {
+		return nil, err
+	}
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return nil, fmt.Errorf("Can't scan '%s': not an array", s)
+	}
+	rv := []string{}
+	if s = strings.TrimSpace(s[1 : len(s)-1]); s == "" {
+		return rv, nil
+	}
+	for _, e := range splitSQLArray(s) {
+		if strings.EqualFold(e, "NULL") {
+			return nil, fmt.Errorf("Can't scan '%s': NULL elements are not supported", s)
+		}
+		rv = append(rv, strings.Trim(e, `"`))
+	}
+	return rv, nil
+}
+
+// splitSQLArray -- split array elements by commas outside of quotes,
+// int8range elements are quoted, because contain commas
+func splitSQLArray(s string) []string {
+	var rv []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				rv = append(rv, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(rv, strings.TrimSpace(s[start:]))
+}
+
+func parseSQLRange(s string) (*IPRange, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "[") || strings.HasPrefix(s, "("):
+		return parseInt8Range(s)
+	case strings.Contains(s, "/"):
+		ip, cidr, err := net.ParseCIDR(s)
+		if err == nil {
+			// IPv4-mapped inet is converted to IPv4 one
+			cidr, err = cidr4(cidr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Can't scan '%s': wrong inet", s)
+		}
+		if !ip.Equal(cidr.IP) {
+			// inet host address
+			return NewIPRange(ip, ip)
+		}
+		return CidrToRange(cidr, false)
+	}
+	return NewRange(s)
+}
+
+// parseInt8Range -- parse PostgreSQL `int8range` literal like `[first,last+1)`
+func parseInt8Range(s string) (*IPRange, error) {
+	if len(s) < 2 || (s[len(s)-1] != ']' && s[len(s)-1] != ')') {
+		return nil, fmt.Errorf("Can't scan '%s': wrong int8range", s)
+	}
+	bounds := strings.Split(s[1:len(s)-1], ",")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("Can't scan '%s': wrong int8range", s)
+	}
+	first, err1 := strconv.ParseInt(strings.TrimSpace(bounds[0]), 10, 64)
+	last, err2 := strconv.ParseInt(strings.TrimSpace(bounds[1]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("Can't scan '%s': unbounded or wrong int8range", s)
+	}
+	if s[0] == '(' {
+		first++
+	}
+	if s[len(s)-1] == ')' {
+		last--
+	}
+	if first < 0 || last > int64(^uint32(0)) || first > last {
+		return nil, fmt.Errorf("Can't scan '%s': int8range is out of IPv4 addresses", s)
+	}
+	return New32Range(uint32(first), uint32(last))
+}
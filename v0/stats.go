@@ -0,0 +1,69 @@
+package cidr32
+
+// PoolStats -- utilization and fragmentation statistics of the pool
+type PoolStats struct {
+	Total int
+	Used  int
+	Free  int
+	// Utilization -- percentage of used addresses
+	Utilization float64
+	// FreeFragments -- amount of free contiguous blocks
+	FreeFragments int
+	// LargestFreeBlock -- largest free contiguous block, nil if pool is full
+	LargestFreeBlock *IPRange
+	// Allocatable -- amount of free aligned CIDRs by prefix length.
+	// Each prefix length is counted independently
+	Allocatable map[int]int
+	// LargestPrefix -- shortest prefix length, which can be allocated.
+	// -1 if pool is full
+	LargestPrefix int
+	// Fragmentation -- 0 if all free addresses are one contiguous block,
+	// tends to 1 when free space is scattered to small blocks
+	Fragmentation float64
+}
+
+// NewPoolStats -- calculate statistics of the pool `total`, where `used` ranges
+// are allocated. Overlapped ranges and used ranges outside the pool are counted once.
+func NewPoolStats(total, used IPRangeList) *PoolStats {
+	pool := total.Arranged()
+	free := pool.Subtract(used)
+	rv := &PoolStats{
+		Total:         pool.Capacity(),
+		Free:          free.Capacity(),
+		FreeFragments: len(free),
+		Allocatable:   map[int]int{},
+		LargestPrefix: -1,
+	}
+	rv.Used = rv.Total - rv.Free
+	if rv.Total > 0 {
+		rv.Utilization = float64(rv.Used) * 100 / float64(rv.Total)
+	}
+	for i := range free {
+		if rv.LargestFreeBlock == nil || free[i].Len() > rv.LargestFreeBlock.Len() {
+			rv.LargestFreeBlock = &free[i]
+		}
+		for n := 0; n <= 32; n++ {
+			size := prefixSize(n)
+			first := (uint64(free[i].First32()) + size - 1) / size
+			last := (uint64(free[i].Last32()) + 1) / size
+			if last > first {
+				rv.Allocatable[n] = rv.Allocatable[n] + int(last-first)
+			}
+		}
+	}
+	for n := 32; n >= 0; n-- {
+		if rv.Allocatable[n] > 0 {
+			rv.LargestPrefix = n
+		}
+	}
+	if rv.Free > 0 {
+		rv.Fragmentation = 1 - float64(rv.LargestFreeBlock.Len())/float64(rv.Free)
+	}
+	return rv
+}
+
+// CanAllocate -- returns true if an aligned CIDR with given prefix length
+// can be allocated from the free space
+func (s *PoolStats) CanAllocate(prefixLen int) bool {
+	return s.Allocatable[prefixLen] > 0
+}
@@ -0,0 +1,172 @@
+package cidr32
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// AllocatorState -- persistent state of the Allocator
+type AllocatorState struct {
+	Pool        IPRangeList  `json:"pool"`
+	Reserved    IPRangeList  `json:"reserved"`
+	Allocations []Allocation `json:"allocations"`
+}
+
+// copy -- returns deep copy of the state
+func (s *AllocatorState) copy() *AllocatorState {
+	rv := &AllocatorState{
+		Pool:        append(IPRangeList{}, s.Pool...),
+		Reserved:    append(IPRangeList{}, s.Reserved...),
+		Allocations: make([]Allocation, len(s.Allocations)),
+	}
+	for i := range s.Allocations {
+		rv.Allocations[i] = s.Allocations[i].clone()
+	}
+	return rv
+}
+
+// Store -- persistent storage of the allocator state.
+// Load should return error, which satisfies os.IsNotExist,
+// if nothing was saved yet
+type Store interface {
+	Load() (*AllocatorState, error)
+	Save(state *AllocatorState) error
+}
+
+// SaveAllocator -- save state of the allocator to the store
+func SaveAllocator(store Store, a *Allocator) error {
+	return store.Save(a.State())
+}
+
+// LoadAllocator -- returns allocator with state, loaded from the store.
+// State is validated by NewAllocatorFromState
+func LoadAllocator(store Store, strategy Strategy) (*Allocator, error) {
+	state, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	return NewAllocatorFromState(state, strategy)
+}
+
+// -----------------------------------------------------------------------------
+
+// MemoryStore -- in-memory Store, keeps a copy of saved state.
+// Safe for concurrent use
+type MemoryStore struct {
+	mu    sync.Mutex
+	state *AllocatorState
+}
+
+// NewMemoryStore -- returns empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Load -- implements Store
+func (s *MemoryStore) Load() (*AllocatorState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return nil, os.ErrNotExist
+	}
+	return s.state.copy(), nil
+}
+
+// Save -- implements Store
+func (s *MemoryStore) Save(state *AllocatorState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state.copy()
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// FileStore -- Store in the file, JSON or binary (gob) encoded.
+// File is replaced atomically: state is written to the temporary file
+// in the same directory, synced to disk and renamed over the old one
+type FileStore struct {
+	Path   string
+	Binary bool
+}
+
+// NewFileStore -- returns JSON file store
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+// Load -- implements Store
+func (s *FileStore) Load() (*AllocatorState, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	state := &AllocatorState{}
+	if s.Binary {
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(state)
+	} else {
+		err = json.Unmarshal(data, state)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "decode", Path: s.Path, Err: err}
+	}
+	return state, nil
+}
+
+// Save -- implements Store
+func (s *FileStore) Save(state *AllocatorState) error {
+	var (
+		data []byte
+		err  error
+	)
+	if s.Binary {
+		buf := &bytes.Buffer{}
+		err = gob.NewEncoder(buf).Encode(state)
+		data = buf.Bytes()
+	} else {
+		data, err = json.MarshalIndent(state, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, data)
+}
+
+// writeFileAtomic -- write data to the temporary file, sync it,
+// rename it to the path and sync the directory
+func writeFileAtomic(path string, data []byte) (err error) {
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	// directory sync is not supported on some platforms, ignore the error
+	if d, derr := os.Open(dir); derr == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package cidr32
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+)
+
+// Strategy -- chooses a block of `size` contiguous addresses for the owner
+// identified by `key`. `pool` is the whole arranged pool,
+// `free` is arranged list of free addresses of the pool
+type Strategy interface {
+	Pick(pool, free IPRangeList, key string, size int) (*IPRange, error)
+}
+
+// FirstFit -- lowest-first strategy, picks the lowest free block
+type FirstFit struct{}
+
+// Pick -- implements Strategy
+func (FirstFit) Pick(pool, free IPRangeList, key string, size int) (*IPRange, error) {
+	return fitFrom(free, 0, size)
+}
+
+// BestFit -- picks the lowest block of the smallest free hole, which fits
+// the requested size. Reduces fragmentation for block allocations
+type BestFit struct{}
+
+// Pick -- implements Strategy
+func (BestFit) Pick(pool, free IPRangeList, key string, size int) (*IPRange, error) {
+	if err := checkBlockSize(size); err != nil {
+		return nil, err
+	}
+	var best *IPRange
+	for i := range free {
+		if free[i].Len() >= size && (best == nil || free[i].Len() < best.Len()) {
+			best = &free[i]
+		}
+	}
+	if best == nil {
+		return nil, ErrPoolExhausted
+	}
+	return blockAt(best.First32(), size), nil
+}
+
+// RandomFit -- picks uniformly random block among all free blocks
+type RandomFit struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewRandomFit -- returns random strategy, which uses given seed
+func NewRandomFit(seed int64) *RandomFit {
+	return &RandomFit{rnd: rand.New(rand.NewSource(seed))}
+}
+
+// Pick -- implements Strategy
+func (s *RandomFit) Pick(pool, free IPRangeList, key string, size int) (*IPRange, error) {
+	if err := checkBlockSize(size); err != nil {
+		return nil, err
+	}
+	total := int64(0)
+	for i := range free {
+		if n := free[i].Len() - size + 1; n > 0 {
+			total = total + int64(n)
+		}
+	}
+	if total == 0 {
+		return nil, ErrPoolExhausted
+	}
+	s.mu.Lock()
+	n := s.rnd.Int63n(total)
+	s.mu.Unlock()
+	for i := range free {
+		if k := int64(free[i].Len() - size + 1); k > 0 {
+			if n < k {
+				return blockAt(free[i].First32()+uint32(n), size), nil
+			}
+			n = n - k
+		}
+	}
+	return nil, ErrPoolExhausted
+}
+
+// RoundRobin -- picks the first free block after the last picked one
+// and wraps around the end of the pool, like CNI host-local IPAM does
+type RoundRobin struct {
+	mu   sync.Mutex
+	next uint32
+}
+
+// Pick -- implements Strategy
+func (s *RoundRobin) Pick(pool, free IPRangeList, key string, size int) (*IPRange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rv, err := fitFrom(free, s.next, size)
+	if err == nil {
+		s.next = rv.Last32() + 1
+	}
+	return rv, err
+}
+
+// indexedStrategy -- Strategy, which uses positional index of the pool.
+// Allocator keeps the index of its pool and passes it to such strategies
+// instead of rebuilding it on each Pick
+type indexedStrategy interface {
+	pickIndexed(pool *IndexedRangeList, free IPRangeList, key string, size int) (*IPRange, error)
+}
+
+// Sticky -- maps hash of the key to the preferred address of the pool
+// and picks the first free block from it, so the same owner tends
+// to get the same address
+type Sticky struct{}
+
+// Pick -- implements Strategy. Builds index of the pool on each call,
+// Allocator uses its own index of the pool instead
+func (s Sticky) Pick(pool, free IPRangeList, key string, size int) (*IPRange, error) {
+	return s.pickIndexed(pool.Indexed(), free, key, size)
+}
+
+func (Sticky) pickIndexed(pool *IndexedRangeList, free IPRangeList, key string, size int) (*IPRange, error) {
+	if pool.Len() == 0 {
+		return nil, ErrPoolExhausted
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	preferred, _ := pool.At(int(h.Sum64() % uint64(pool.Len())))
+	return fitFrom(free, preferred, size)
+}
+
+// ----------------------------------------------------------------------------
+
+// fitFrom -- returns the first block of `size` addresses inside free holes,
+// which starts not before `start`. Search is wrapped to the beginning
+// if there is no such block after `start`
+func fitFrom(free IPRangeList, start uint32, size int) (*IPRange, error) {
+	if err := checkBlockSize(size); err != nil {
+		return nil, err
+	}
+	for i := range free {
+		first := free[i].First32()
+		if first < start {
+			if free[i].Last32() < start {
+				continue
+			}
+			first = start
+		}
+		if uint64(free[i].Last32())-uint64(first)+1 >= uint64(size) {
+			return blockAt(first, size), nil
+		}
+	}
+	if start > 0 {
+		return fitFrom(free, 0, size)
+	}
+	return nil, ErrPoolExhausted
+}
+
+func checkBlockSize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("Wrong block size %d", size)
+	}
+	return nil
+}
+
+func blockAt(first uint32, size int) *IPRange {
+	return &IPRange{i32: [2]uint32{first, first + uint32(size-1)}}
+}
@@ -0,0 +1,195 @@
+package cidr32
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrTxConflict -- concurrent changes of the allocator overlap
+	// with operations of the transaction
+	ErrTxConflict = errors.New("Transaction conflicts with concurrent changes")
+	// ErrTxDone -- transaction was already committed or rolled back
+	ErrTxDone = errors.New("Transaction is already committed or rolled back")
+)
+
+// txOp -- allocation or release, made in the transaction
+type txOp struct {
+	alloc   Allocation
+	release bool
+}
+
+// Tx -- batch of allocations and releases, which are applied to the
+// allocator atomically by Commit or discarded by Rollback.
+// Operations are made on the private copy of the allocator state,
+// other users of the allocator don't see them until Commit.
+// Commit fails with ErrTxConflict only if concurrent changes, made after
+// Begin, overlap with operations of the transaction, i.e. the range became
+// allocated or the released allocation was released by somebody else.
+// The caller may retry with the new transaction.
+// Strategies with internal state, like RoundRobin, keep their state
+// after Rollback. Safe for concurrent use
+type Tx struct {
+	mu     sync.Mutex
+	a      *Allocator
+	shadow *Allocator
+	ops    []txOp
+	done   bool
+}
+
+// Begin -- start the transaction
+func (a *Allocator) Begin() *Tx {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return &Tx{
+		a:      a,
+		shadow: a.shadow(),
+		ops:    []txOp{},
+	}
+}
+
+// shadow -- returns private copy of the allocator state for transactions.
+// Allocations are copied too, because Annotate changes them in place.
+// Quarantined addresses are reserved in it, the copy has no quarantine
+func (a *Allocator) shadow() *Allocator {
+	entries := a.allocs.Entries()
+	for i := range entries {
+		alloc := entries[i].Value.(*Allocation).clone()
+		entries[i].Value = &alloc
+	}
+	shadow := &Allocator{
+		pool:     a.pool,
+		index:    a.index,
+		reserved: append(IPRangeList{}, a.reserved...),
+		strategy: a.strategy,
+		allocs:   &IPRangeMap{entries: entries},
+		managed:  a.managed,
+	}
+	if a.quarantine != nil {
+		// quarantined addresses are not free in the transaction too
+		shadow.reserved = append(shadow.reserved, a.quarantine.Ranges()...).Arranged()
+	}
+	return shadow
+}
+
+// Allocate -- allocate one address to the owner in the transaction
+func (tx *Tx) Allocate(owner string) (Allocation, error) {
+	return tx.AllocateBlock(owner, 1)
+}
+
+// AllocateBlock -- allocate block of `size` contiguous addresses
+// to the owner in the transaction
+func (tx *Tx) AllocateBlock(owner string, size int) (Allocation, error) {
+	return tx.allocate(func() (Allocation, error) {
+		return tx.shadow.AllocateBlock(owner, size)
+	})
+}
+
+// AllocateSpecific -- allocate given range to the owner in the transaction.
+// The range should be inside the pool and free
+func (tx *Tx) AllocateSpecific(owner string, r *IPRange) (Allocation, error) {
+	return tx.allocate(func() (Allocation, error) {
+		return tx.shadow.AllocateSpecific(owner, r)
+	})
+}
+
+// Release -- release the allocation, which contains the address,
+// in the transaction
+func (tx *Tx) Release(ip uint32) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	alloc, _ := tx.shadow.Lookup(ip)
+	if err := tx.shadow.Release(ip); err != nil {
+		return err
+	}
+	tx.a.mu.Lock()
+	quarantine := tx.a.quarantine != nil
+	tx.a.mu.Unlock()
+	if quarantine {
+		// address will be quarantined on commit, so it can't be reused now
+		tx.shadow.Reserve(&alloc.Range)
+	}
+	tx.ops = append(tx.ops, txOp{alloc: alloc, release: true})
+	return nil
+}
+
+// Allocations -- returns allocations, as they will be after the commit
+func (tx *Tx) Allocations() []Allocation {
+	return tx.shadow.Allocations()
+}
+
+// Commit -- apply all operations of the transaction to the allocator.
+// Operations are checked against the current state of the allocator first:
+// allocated ranges should be still free, released allocations should still
+// exist. Returns ErrTxConflict and leaves the allocator unchanged otherwise
+func (tx *Tx) Commit() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	a := tx.a
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.replay(a.shadow(), tx.ops) {
+		return ErrTxConflict
+	}
+	for i := range tx.ops {
+		op := &tx.ops[i]
+		if op.release {
+			e, _ := a.allocs.Lookup(op.alloc.Range.First32())
+			a.remove(e.Value.(*Allocation))
+		} else {
+			a.add(op.alloc.Owner, &op.alloc.Range)
+		}
+	}
+	return nil
+}
+
+// Rollback -- discard all operations of the transaction.
+// Rollback after Commit does nothing, so it may be deferred
+func (tx *Tx) Rollback() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.done = true
+}
+
+// replay -- apply operations to the copy of the allocator state,
+// returns false if any of them conflicts with the state
+func (a *Allocator) replay(shadow *Allocator, ops []txOp) bool {
+	for i := range ops {
+		op := &ops[i]
+		if !op.release {
+			if _, err := shadow.AllocateSpecific(op.alloc.Owner, &op.alloc.Range); err != nil {
+				return false
+			}
+			continue
+		}
+		e, ok := shadow.allocs.Lookup(op.alloc.Range.First32())
+		if !ok || e.Range != op.alloc.Range || e.Value.(*Allocation).Owner != op.alloc.Owner {
+			return false
+		}
+		shadow.remove(e.Value.(*Allocation))
+		if a.quarantine != nil {
+			shadow.reserved = append(shadow.reserved, op.alloc.Range).Arranged()
+		}
+	}
+	return true
+}
+
+func (tx *Tx) allocate(fn func() (Allocation, error)) (Allocation, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return Allocation{}, ErrTxDone
+	}
+	alloc, err := fn()
+	if err == nil {
+		tx.ops = append(tx.ops, txOp{alloc: alloc})
+	}
+	return alloc, err
+}